@@ -0,0 +1,187 @@
+package clients
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/smartcontractkit/helmenv/environment"
+)
+
+const (
+	// ChainlinkAccessPort name of the port of the chainlink REST API and operator UI
+	ChainlinkAccessPort = "access"
+	// ChainlinkEmailCredential name of the credential holding the API email of a chainlink chart
+	ChainlinkEmailCredential = "email"
+	// ChainlinkPasswordCredential name of the credential holding the API password of a chainlink chart
+	ChainlinkPasswordCredential = "password"
+)
+
+// Chainlink is a client of the REST API of a chainlink node, it logs in when created and again when the session
+// expires
+type Chainlink struct {
+	URL *url.URL
+
+	email    string
+	password environment.Redacted
+	client   *http.Client
+}
+
+// Resource a JSON:API resource returned by the chainlink API
+type Resource struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// Bridge an external adapter registered in a chainlink node
+type Bridge struct {
+	Name                   string `json:"name"`
+	URL                    string `json:"url"`
+	Confirmations          uint32 `json:"confirmations,omitempty"`
+	MinimumContractPayment string `json:"minimumContractPayment,omitempty"`
+}
+
+type resourceResponse struct {
+	Data Resource `json:"data"`
+}
+
+type resourcesResponse struct {
+	Data []Resource `json:"data"`
+}
+
+// NewChainlink creates a client of an instance of a chainlink chart, logged in with the credentials of the chart
+func NewChainlink(e *environment.Environment, chartName string, instance int, mode Mode) (*Chainlink, error) {
+	c, err := chart(e, chartName)
+	if err != nil {
+		return nil, err
+	}
+	u, err := connectionURL(e.Charts.Query().Chart(chartName).Instance(instance).Port(ChainlinkAccessPort), mode)
+	if err != nil {
+		return nil, err
+	}
+	email, err := c.ResolveCredential(ChainlinkEmailCredential)
+	if err != nil {
+		return nil, err
+	}
+	password, err := c.ResolveCredential(ChainlinkPasswordCredential)
+	if err != nil {
+		return nil, err
+	}
+	return NewChainlinkFromURL(u.String(), email.Value(), password)
+}
+
+// NewChainlinkFromURL creates a client of the chainlink node at the URL, logged in with the credentials
+func NewChainlinkFromURL(rawURL string, email string, password environment.Redacted) (*Chainlink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &Chainlink{
+		URL:      u,
+		email:    email,
+		password: password,
+		client:   &http.Client{Jar: jar, Timeout: DefaultTimeout},
+	}
+	return c, c.Login()
+}
+
+// Login creates a new session
+func (c *Chainlink) Login() error {
+	body := map[string]string{"email": c.email, "password": c.password.Value()}
+	if err := doJSON(c.client, http.MethodPost, c.endpoint("/sessions"), body, nil); err != nil {
+		return errors.Wrapf(err, "failed to log in to chainlink node %s", c.URL)
+	}
+	log.Debug().Str("URL", c.URL.String()).Msg("Logged in to chainlink node")
+	return nil
+}
+
+// ReadKeys returns the keys of a type, e.g. eth, p2p, ocr, csa or vrf
+func (c *Chainlink) ReadKeys(keyType string) ([]Resource, error) {
+	resp := &resourcesResponse{}
+	if err := c.do(http.MethodGet, "/v2/keys/"+keyType, nil, resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// ReadETHAddresses returns the addresses of the ETH keys of the node
+func (c *Chainlink) ReadETHAddresses() ([]string, error) {
+	keys, err := c.ReadKeys("eth")
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if address, ok := key.Attributes["address"].(string); ok {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+// CreateJob creates a job from a TOML job spec
+func (c *Chainlink) CreateJob(spec string) (*Resource, error) {
+	resp := &resourceResponse{}
+	if err := c.do(http.MethodPost, "/v2/jobs", map[string]string{"toml": spec}, resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// ReadJobs returns all the jobs of the node
+func (c *Chainlink) ReadJobs() ([]Resource, error) {
+	resp := &resourcesResponse{}
+	if err := c.do(http.MethodGet, "/v2/jobs", nil, resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// DeleteJob deletes a job by ID
+func (c *Chainlink) DeleteJob(id string) error {
+	return c.do(http.MethodDelete, "/v2/jobs/"+url.PathEscape(id), nil, nil)
+}
+
+// CreateBridge registers an external adapter
+func (c *Chainlink) CreateBridge(bridge *Bridge) error {
+	return c.do(http.MethodPost, "/v2/bridge_types", bridge, nil)
+}
+
+// ReadBridge returns a bridge by name
+func (c *Chainlink) ReadBridge(name string) (*Resource, error) {
+	resp := &resourceResponse{}
+	if err := c.do(http.MethodGet, "/v2/bridge_types/"+url.PathEscape(name), nil, resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// DeleteBridge deletes a bridge by name
+func (c *Chainlink) DeleteBridge(name string) error {
+	return c.do(http.MethodDelete, "/v2/bridge_types/"+url.PathEscape(name), nil, nil)
+}
+
+// do sends a request and logs in again once if the session expired
+func (c *Chainlink) do(method string, path string, body interface{}, out interface{}) error {
+	err := doJSON(c.client, method, c.endpoint(path), body, out)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		return err
+	}
+	if err := c.Login(); err != nil {
+		return err
+	}
+	return doJSON(c.client, method, c.endpoint(path), body, out)
+}
+
+func (c *Chainlink) endpoint(path string) string {
+	return strings.TrimSuffix(c.URL.String(), "/") + path
+}
@@ -0,0 +1,93 @@
+// Package clients has ready-made clients for the apps of the embedded charts, built from the connections of an
+// environment
+package clients
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/smartcontractkit/helmenv/environment"
+)
+
+// DefaultTimeout timeout of the requests of all clients
+const DefaultTimeout = 30 * time.Second
+
+// Mode selects whether a client connects through the local ports of a connected environment, or through in-cluster
+// URLs when running inside the environment namespace, e.g. in the remote test runner
+type Mode int
+
+const (
+	// Local connects through the local ports
+	Local Mode = iota
+	// Remote connects through pod IPs
+	Remote
+)
+
+// connectionURL returns the HTTP URL of the single port matched by the query
+func connectionURL(query *environment.ConnectionQuery, mode Mode) (*url.URL, error) {
+	if mode == Remote {
+		return query.RemoteURL(environment.HTTP)
+	}
+	return query.LocalURL(environment.HTTP)
+}
+
+// chart returns a chart of the environment by name
+func chart(e *environment.Environment, chartName string) (*environment.HelmChart, error) {
+	c, ok := e.Charts[chartName]
+	if !ok {
+		return nil, fmt.Errorf("chart %s doesn't exist in the environment", chartName)
+	}
+	return c, nil
+}
+
+// StatusError is returned when a response has a non 2xx status code
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s returned status %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// doJSON sends the body as JSON and decodes the response into out, if not nil
+func doJSON(client *http.Client, method string, u string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Method: method, URL: u, StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
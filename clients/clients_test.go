@@ -0,0 +1,133 @@
+package clients_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/clients"
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainlink(t *testing.T) {
+	logins := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		var creds map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&creds))
+		if creds["email"] != "admin@chain.link" || creds["password"] != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		logins++
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "valid"})
+	})
+	mux.HandleFunc("/v2/jobs", func(w http.ResponseWriter, r *http.Request) {
+		// the first session expires right away
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "valid" || logins < 2 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"type":"jobs","id":"1","attributes":{"name":"test"}}}`))
+	})
+	mux.HandleFunc("/v2/keys/eth", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[{"type":"eTHKeys","id":"0x1","attributes":{"address":"0x1"}}]}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	_, err := clients.NewChainlinkFromURL(ts.URL, "admin@chain.link", "wrong")
+	require.Error(t, err)
+
+	c, err := clients.NewChainlinkFromURL(ts.URL, "admin@chain.link", "secret")
+	require.NoError(t, err)
+	job, err := c.CreateJob(`type = "cron"`)
+	require.NoError(t, err)
+	require.Equal(t, "1", job.ID)
+	require.Equal(t, 2, logins)
+	addresses, err := c.ReadETHAddresses()
+	require.NoError(t, err)
+	require.Equal(t, []string{"0x1"}, addresses)
+}
+
+func TestEthereum(t *testing.T) {
+	unlocked := map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     int64         `json:"id"`
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		var result interface{}
+		switch req.Method {
+		case "eth_accounts":
+			result = []string{"0xa", "0xb"}
+		case "eth_getBalance":
+			result = "0x0"
+			if req.Params[0] == "0xa" {
+				result = "0xde0b6b3a7640000"
+			}
+		case "personal_unlockAccount":
+			unlocked[req.Params[0].(string)] = req.Params[1].(string)
+			result = true
+		default:
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "error": map[string]interface{}{"code": -32601, "message": "method not found"}})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "result": result})
+	}))
+	defer ts.Close()
+
+	c, err := clients.NewEthereumFromURL(ts.URL, "keystore-password")
+	require.NoError(t, err)
+	funded, err := c.FundedAccounts()
+	require.NoError(t, err)
+	require.Equal(t, []string{"0xa"}, funded)
+	require.Equal(t, map[string]string{"0xa": "keystore-password"}, unlocked)
+	balance, err := c.Balance("0xa")
+	require.NoError(t, err)
+	require.Equal(t, "1000000000000000000", balance.String())
+	require.Equal(t, "0xde0b6b3a7640000", clients.Quantity(big.NewInt(1000000000000000000)))
+	_, err = c.ChainID()
+	require.EqualError(t, err, "eth_chainId: rpc error -32601: method not found")
+}
+
+func TestMockserver(t *testing.T) {
+	var paths []string
+	var expectations []map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/mockserver/expectation":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&expectations))
+		case "/mockserver/verify":
+			w.WriteHeader(http.StatusNotAcceptable)
+			_, _ = w.Write([]byte("Request not found at least once"))
+		}
+	}))
+	defer ts.Close()
+
+	c, err := clients.NewMockserverFromURL(ts.URL)
+	require.NoError(t, err)
+	adapter := &environment.MockserverExpectation{Path: "/variable", Method: "POST", Sequence: []interface{}{
+		map[string]interface{}{"result": 5},
+		map[string]interface{}{"result": 6},
+	}}
+	require.NoError(t, c.SetExpectations(adapter))
+	require.Len(t, expectations, 2)
+	require.Equal(t, "post-variable-0", expectations[0]["id"])
+	require.EqualError(t, c.Verify(adapter, 1, 1), "verification of POST /variable failed: Request not found at least once")
+	require.NoError(t, c.Clear("/"))
+	require.NoError(t, c.Reset())
+	require.Equal(t, []string{
+		"/mockserver/expectation",
+		"/mockserver/verify",
+		"/mockserver/clear",
+		"/mockserver/reset",
+	}, paths)
+}
@@ -0,0 +1,194 @@
+package clients
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/smartcontractkit/helmenv/environment"
+)
+
+const (
+	// EthereumHTTPPort name of the JSON-RPC HTTP port of the geth and geth-reorg charts
+	EthereumHTTPPort = "http-rpc"
+	// EthereumAccountPasswordCredential name of the credential holding the keystore password of a geth chart
+	EthereumAccountPasswordCredential = "account-password"
+)
+
+// Ethereum is a minimal JSON-RPC client of the geth nodes of the geth and geth-reorg charts, transactions are signed
+// by the node with the accounts of its keystore
+type Ethereum struct {
+	URL *url.URL
+
+	password environment.Redacted
+	client   *http.Client
+	id       int64
+}
+
+// Transaction a transaction to be signed and sent by the node
+type Transaction struct {
+	From     string `json:"from"`
+	To       string `json:"to,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Gas      string `json:"gas,omitempty"`
+	GasPrice string `json:"gasPrice,omitempty"`
+	Data     string `json:"data,omitempty"`
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int64         `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result interface{} `json:"result"`
+	Error  *RPCError   `json:"error"`
+}
+
+// RPCError an error returned by the node
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// NewEthereum creates a client of an instance of a geth or geth-reorg chart, the keystore password is read from the
+// account-password credential of the chart if it's declared
+func NewEthereum(e *environment.Environment, chartName string, instance int, mode Mode) (*Ethereum, error) {
+	c, err := chart(e, chartName)
+	if err != nil {
+		return nil, err
+	}
+	u, err := connectionURL(e.Charts.Query().Chart(chartName).Instance(instance).Port(EthereumHTTPPort), mode)
+	if err != nil {
+		return nil, err
+	}
+	var password environment.Redacted
+	for _, credential := range c.Credentials {
+		if credential.Name == EthereumAccountPasswordCredential {
+			if password, err = c.ResolveCredential(EthereumAccountPasswordCredential); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return NewEthereumFromURL(u.String(), password)
+}
+
+// NewEthereumFromURL creates a client of the node at the URL, the password unlocks the accounts of its keystore
+func NewEthereumFromURL(rawURL string, password environment.Redacted) (*Ethereum, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Ethereum{
+		URL:      u,
+		password: password,
+		client:   &http.Client{Timeout: DefaultTimeout},
+	}, nil
+}
+
+// Call calls a JSON-RPC method and decodes the result into result, if not nil
+func (c *Ethereum) Call(result interface{}, method string, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	req := rpcRequest{JSONRPC: "2.0", ID: atomic.AddInt64(&c.id, 1), Method: method, Params: params}
+	resp := &rpcResponse{Result: result}
+	if err := doJSON(c.client, http.MethodPost, c.URL.String(), req, resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return errors.Wrap(resp.Error, method)
+	}
+	return nil
+}
+
+// ChainID returns the chain ID of the network
+func (c *Ethereum) ChainID() (*big.Int, error) {
+	var id string
+	if err := c.Call(&id, "eth_chainId"); err != nil {
+		return nil, err
+	}
+	return parseQuantity(id)
+}
+
+// Accounts returns the accounts of the keystore of the node
+func (c *Ethereum) Accounts() ([]string, error) {
+	var accounts []string
+	return accounts, c.Call(&accounts, "eth_accounts")
+}
+
+// Balance returns the latest balance of an address in wei
+func (c *Ethereum) Balance(address string) (*big.Int, error) {
+	var balance string
+	if err := c.Call(&balance, "eth_getBalance", address, "latest"); err != nil {
+		return nil, err
+	}
+	return parseQuantity(balance)
+}
+
+// FundedAccounts returns the keystore accounts that have a balance, unlocked so the node can sign with them
+func (c *Ethereum) FundedAccounts() ([]string, error) {
+	accounts, err := c.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	var funded []string
+	for _, account := range accounts {
+		balance, err := c.Balance(account)
+		if err != nil {
+			return nil, err
+		}
+		if balance.Sign() <= 0 {
+			continue
+		}
+		if err := c.UnlockAccount(account); err != nil {
+			return nil, err
+		}
+		funded = append(funded, account)
+	}
+	log.Debug().Str("URL", c.URL.String()).Int("Accounts", len(funded)).Msg("Found funded accounts")
+	return funded, nil
+}
+
+// UnlockAccount unlocks a keystore account until the node restarts
+func (c *Ethereum) UnlockAccount(address string) error {
+	var unlocked bool
+	if err := c.Call(&unlocked, "personal_unlockAccount", address, c.password.Value(), 0); err != nil {
+		return err
+	}
+	if !unlocked {
+		return fmt.Errorf("failed to unlock account %s", address)
+	}
+	return nil
+}
+
+// SendTransaction sends a transaction signed by the node and returns its hash
+func (c *Ethereum) SendTransaction(tx *Transaction) (string, error) {
+	var hash string
+	return hash, c.Call(&hash, "eth_sendTransaction", tx)
+}
+
+// Quantity encodes an amount as a JSON-RPC quantity
+func Quantity(amount *big.Int) string {
+	return "0x" + amount.Text(16)
+}
+
+func parseQuantity(quantity string) (*big.Int, error) {
+	value, ok := new(big.Int).SetString(strings.TrimPrefix(quantity, "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid quantity %s", strconv.Quote(quantity))
+	}
+	return value, nil
+}
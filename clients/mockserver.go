@@ -0,0 +1,78 @@
+package clients
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smartcontractkit/helmenv/environment"
+)
+
+const (
+	// MockserverPort name of the port of the mockserver chart
+	MockserverPort = "serviceport"
+)
+
+// Mockserver is a client of the REST API of mockserver, used to manage expectations while tests run
+type Mockserver struct {
+	URL *url.URL
+
+	client *http.Client
+}
+
+// NewMockserver creates a client of the mockserver chart
+func NewMockserver(e *environment.Environment, chartName string, mode Mode) (*Mockserver, error) {
+	if _, err := chart(e, chartName); err != nil {
+		return nil, err
+	}
+	u, err := connectionURL(e.Charts.Query().Chart(chartName).Instance(0).Port(MockserverPort), mode)
+	if err != nil {
+		return nil, err
+	}
+	return NewMockserverFromURL(u.String())
+}
+
+// NewMockserverFromURL creates a client of the mockserver at the URL
+func NewMockserverFromURL(rawURL string) (*Mockserver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Mockserver{URL: u, client: &http.Client{Timeout: DefaultTimeout}}, nil
+}
+
+// PutExpectations creates or replaces expectations, given in the JSON format of the mockserver API
+func (c *Mockserver) PutExpectations(expectations interface{}) error {
+	return doJSON(c.client, http.MethodPut, c.endpoint("/mockserver/expectation"), expectations, nil)
+}
+
+// SetExpectations creates or replaces expectations
+func (c *Mockserver) SetExpectations(expectations ...*environment.MockserverExpectation) error {
+	return c.PutExpectations(environment.RenderMockserverExpectations(expectations))
+}
+
+// Verify checks that requests matching the expectation were received between atLeast and atMost times
+func (c *Mockserver) Verify(expectation *environment.MockserverExpectation, atLeast, atMost int) error {
+	err := doJSON(c.client, http.MethodPut, c.endpoint("/mockserver/verify"), expectation.Verification(atLeast, atMost), nil)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotAcceptable {
+		return fmt.Errorf("verification of %s %s failed: %s", expectation.Method, expectation.Path, statusErr.Body)
+	}
+	return err
+}
+
+// Clear removes the expectations and recorded requests matching the path
+func (c *Mockserver) Clear(path string) error {
+	return doJSON(c.client, http.MethodPut, c.endpoint("/mockserver/clear"), map[string]string{"path": path}, nil)
+}
+
+// Reset removes all expectations and recorded requests
+func (c *Mockserver) Reset() error {
+	return doJSON(c.client, http.MethodPut, c.endpoint("/mockserver/reset"), nil, nil)
+}
+
+func (c *Mockserver) endpoint(path string) string {
+	return strings.TrimSuffix(c.URL.String(), "/") + path
+}
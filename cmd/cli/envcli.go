@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -29,6 +33,150 @@ var environmentFlag = &cli.StringFlag{
 	Required: true,
 }
 
+var valuesFlag = &cli.StringSliceFlag{
+	Name:     "values",
+	Aliases:  []string{"f"},
+	Usage:    "values file of a chart as chart=path, applied after the values files the chart already has",
+	Required: false,
+}
+
+var setFlag = &cli.StringSliceFlag{
+	Name:     "set",
+	Usage:    "value of a chart as chart.key.path=value, the chart is what comes before the first dot, takes precedence over values files and inline values",
+	Required: false,
+}
+
+// exportConnections writes the connection details of the environment to a file, or stdout if no path is given
+func exportConnections(e *environment.Environment, format environment.ExportFormat, path string) error {
+	if path == "" {
+		return e.ExportConnections(os.Stdout, format)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := e.ExportConnections(f, format); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Info().Str("Path", path).Str("Format", string(format)).Msg("Connection details exported")
+	return nil
+}
+
+// lockEnvironment locks the environment file for the read-modify-write cycle of a command, the returned function
+// releases it
+func lockEnvironment(path string) (func(), error) {
+	lock, err := environment.LockConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := lock.Unlock(); err != nil {
+			log.Error().Err(err).Msg("Error while unlocking environment file")
+		}
+	}, nil
+}
+
+// connectEnvironment connects to the environment holding the lock of the environment file until the connections are
+// written to it
+func connectEnvironment(c *cli.Context, environmentPath string) (*environment.Environment, error) {
+	unlock, err := lockEnvironment(environmentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	e, err := environment.DeployOrLoadEnvironmentFromConfigFile(environmentPath)
+	if err != nil {
+		return nil, err
+	}
+	if c.IsSet("strategy") {
+		e.Config.ConnectionStrategy = c.String("strategy")
+	}
+	if c.Bool("gateway") || c.IsSet("gateway-port") {
+		e.Config.Gateway = &environment.GatewayConfig{Enabled: true, Port: c.Int("gateway-port")}
+	}
+	if err := e.ConnectAll(); err != nil {
+		return nil, err
+	}
+	if c.IsSet("export-format") {
+		if err := exportConnections(e, environment.ExportFormat(c.String("export-format")), c.String("export-file")); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// connectEnvironmentPath returns the environment file of the connect command, with --namespace the file is written
+// from the state kept in the namespace, named after the namespace unless --environment is given. An existing file is
+// only replaced if it's for the same namespace
+func connectEnvironmentPath(c *cli.Context) (string, error) {
+	environmentPath := c.String("environment")
+	namespace := c.String("namespace")
+	if namespace == "" {
+		if environmentPath == "" {
+			return "", fmt.Errorf("either --environment or --namespace is required")
+		}
+		return environmentPath, nil
+	}
+	config, err := environment.ReadClusterState(namespace)
+	if err != nil {
+		return "", err
+	}
+	if environmentPath == "" {
+		environmentPath = namespace + ".yaml"
+	}
+	unlock, err := lockEnvironment(environmentPath)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	if _, err := os.Stat(environmentPath); err == nil {
+		existing, err := environment.ReadConfigFile(environmentPath)
+		if err != nil {
+			return "", err
+		}
+		if existing.Namespace != namespace {
+			return "", fmt.Errorf("environment file %s is for namespace %s, not %s", environmentPath, existing.Namespace, namespace)
+		}
+	}
+	if err := (&environment.FileStore{Path: environmentPath}).Save(config); err != nil {
+		return "", err
+	}
+	log.Info().Str("Namespace", namespace).Str("Path", environmentPath).Msg("Environment file written from the state of the namespace")
+	return environmentPath, nil
+}
+
+// daemonArgs builds the arguments of a detached connect process from the flags of the connect command
+func daemonArgs(c *cli.Context, environmentPath string) []string {
+	args := []string{"connect", "--daemon", "--environment", environmentPath}
+	for _, name := range []string{"strategy", "gateway-port", "export-format", "export-file"} {
+		if c.IsSet(name) {
+			args = append(args, "--"+name, c.String(name))
+		}
+	}
+	if c.Bool("gateway") {
+		args = append(args, "--gateway")
+	}
+	return args
+}
+
+// printDaemonStatus prints the forwards of a connect daemon as a table
+func printDaemonStatus(status *environment.DaemonStatus) {
+	fmt.Printf("Namespace: %s\nPid: %d\nConnected since: %s\n", status.Namespace, status.Pid, status.StartedAt.Format(time.RFC3339))
+	if status.GatewayURL != "" {
+		fmt.Printf("Gateway: %s\n", status.GatewayURL)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHART\tCONNECTION\tPORT\tLOCAL ADDRESS\tHEALTHY")
+	for _, f := range status.Forwards {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", f.Chart, f.Connection, f.Port, f.LocalAddress, strconv.FormatBool(f.Healthy))
+	}
+	_ = w.Flush()
+}
+
 func main() {
 	app := &cli.App{
 		Name:  "envcli",
@@ -46,6 +194,8 @@ func main() {
 						Usage:    "file path for the outputted environment config",
 						Required: false,
 					},
+					valuesFlag,
+					setFlag,
 				},
 				Action: func(c *cli.Context) error {
 					preset := c.String("preset")
@@ -53,7 +203,7 @@ func main() {
 					if err := os.Setenv("CONFIG_PATH", c.String("outputFile")); err != nil {
 						return err
 					}
-					e, err := environment.DeployOrLoadEnvironmentFromConfigFile(preset)
+					e, err := environment.DeployOrLoadEnvironmentWithOverrides(preset, c.StringSlice("values"), c.StringSlice("set"))
 					if err != nil {
 						return err
 					}
@@ -63,23 +213,185 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name:  "validate",
+				Usage: "validates a preset and the values of its charts without deploying it",
+				Flags: []cli.Flag{
+					presetFlag,
+					valuesFlag,
+					setFlag,
+					&cli.BoolFlag{
+						Name:     "strict",
+						Usage:    "reject values that aren't in the default values of charts without a schema",
+						Required: false,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					config, err := environment.ReadConfigFile(c.String("preset"))
+					if err != nil {
+						return err
+					}
+					if _, err := config.Charts.ApplyOverrides(c.StringSlice("values"), c.StringSlice("set")); err != nil {
+						return err
+					}
+					if c.Bool("strict") {
+						config.UnknownValues = environment.RejectUnknownValues
+					}
+					if err := config.Validate(); err != nil {
+						return err
+					}
+					if err := config.ValidateValues(); err != nil {
+						return err
+					}
+					log.Info().Str("Preset", c.String("preset")).Msg("Values of all charts are valid")
+					return nil
+				},
+			},
+			{
+				Name:    "upgrade",
+				Aliases: []string{"u"},
+				Usage:   "upgrades charts of the environment with new values",
+				Flags: []cli.Flag{
+					environmentFlag,
+					valuesFlag,
+					setFlag,
+					&cli.StringSliceFlag{
+						Name:     "chart",
+						Usage:    "chart to upgrade even if no values are given for it",
+						Required: false,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					unlock, err := lockEnvironment(c.String("environment"))
+					if err != nil {
+						return err
+					}
+					defer unlock()
+					e, err := environment.DeployOrLoadEnvironmentFromConfigFile(c.String("environment"))
+					if err != nil {
+						return err
+					}
+					charts, err := e.Charts.ApplyOverrides(c.StringSlice("values"), c.StringSlice("set"))
+					if err != nil {
+						return err
+					}
+					upgraded := map[string]bool{}
+					for _, chart := range charts {
+						upgraded[chart] = true
+					}
+					for _, chart := range c.StringSlice("chart") {
+						if !upgraded[chart] {
+							upgraded[chart] = true
+							charts = append(charts, chart)
+						}
+					}
+					if len(charts) == 0 {
+						return fmt.Errorf("no chart to upgrade, give values with --values/--set or a --chart")
+					}
+					for _, chart := range charts {
+						log.Info().Str("Chart", chart).Msg("Upgrading chart")
+						if err := e.Upgrade(chart); err != nil {
+							return err
+						}
+					}
+					log.Info().Str("environmentFile", e.Path).Msg("Environment upgraded")
+					return nil
+				},
+			},
 			{
 				Name:    "connect",
 				Aliases: []string{"c"},
 				Usage:   "connects to selected environment",
-				Flags:   []cli.Flag{environmentFlag},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "environment",
+						Aliases:  []string{"e"},
+						Usage:    "filepath to the environment file, written from the state of the namespace with --namespace",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "namespace",
+						Aliases:  []string{"n"},
+						Usage:    "namespace of an environment keeping its state in a secret or configmap, to connect without its environment file",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "strategy",
+						Aliases:  []string{"s"},
+						Usage:    "connection strategy: port-forward, node-port, ingress or load-balancer",
+						Required: false,
+					},
+					&cli.BoolFlag{
+						Name:     "gateway",
+						Aliases:  []string{"g"},
+						Usage:    "serve all connections through a single local HTTP/WebSocket gateway",
+						Required: false,
+					},
+					&cli.IntFlag{
+						Name:     "gateway-port",
+						Usage:    "local port of the gateway, a random free port is used by default",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "export-format",
+						Aliases:  []string{"x"},
+						Usage:    "export connection details as dotenv, shell or json",
+						Required: false,
+					},
+					&cli.StringFlag{
+						Name:     "export-file",
+						Usage:    "file path for the exported connection details, stdout by default",
+						Required: false,
+					},
+					&cli.BoolFlag{
+						Name:     "detach",
+						Aliases:  []string{"d"},
+						Usage:    "keep the connection open in a background process, see `envcli status` and `envcli disconnect`",
+						Required: false,
+					},
+					&cli.BoolFlag{
+						Name:   "daemon",
+						Usage:  "run as the background process of a detached connection",
+						Hidden: true,
+					},
+				},
 				Action: func(c *cli.Context) error {
-					environmentPath := c.String("environment")
-					e, err := environment.DeployOrLoadEnvironmentFromConfigFile(environmentPath)
+					environmentPath, err := connectEnvironmentPath(c)
 					if err != nil {
 						return err
 					}
-					if err := e.ConnectAll(); err != nil {
+					if c.Bool("detach") {
+						if c.IsSet("export-format") && !c.IsSet("export-file") {
+							return fmt.Errorf("--export-file is required to export connection details with --detach")
+						}
+						status, err := environment.StartConnectDaemon(environmentPath, daemonArgs(c, environmentPath))
+						if err != nil {
+							return err
+						}
+						log.Info().
+							Str("Namespace", status.Namespace).
+							Int("Pid", status.Pid).
+							Msgf("Connected in the background, run `envcli disconnect -e %s` to disconnect", environmentPath)
+						return nil
+					}
+					e, err := connectEnvironment(c, environmentPath)
+					if err != nil {
 						return err
 					}
+					if !c.Bool("daemon") && !e.IsPortForwarded() && (e.Config.Gateway == nil || !e.Config.Gateway.Enabled) {
+						log.Info().
+							Str("Namespace", e.Namespace).
+							Msgf("Connected, view output or `%s` file for connection details", e.Path)
+						return nil
+					}
 					defer func() {
 						e.Disconnect()
-						if err := e.ClearConfigLocalPorts(); err != nil {
+						// other commands may have changed the environment file while connected
+						err := environment.UpdateConfigFile(e.Path, func(config *environment.Config) error {
+							config.ClearLocalPorts()
+							return nil
+						})
+						if err != nil {
 							log.Error().Err(err).Msg("Error while clearing local ports in environment config")
 						}
 						log.Info().Str("Namespace", e.Namespace).Msg("Disconnected from environment")
@@ -90,11 +402,49 @@ func main() {
 
 					// Wait until the user wants to stop the connection to the environment
 					interrupt := make(chan os.Signal, 1)
-					signal.Notify(interrupt, os.Interrupt)
+					signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+					if c.Bool("daemon") {
+						return environment.ServeConnectDaemon(e, interrupt)
+					}
 					<-interrupt
 					return nil
 				},
 			},
+			{
+				Name:  "status",
+				Usage: "shows the forwarded ports of a background connection and their health",
+				Flags: []cli.Flag{environmentFlag},
+				Action: func(c *cli.Context) error {
+					status, err := environment.QueryConnectDaemon(c.String("environment"))
+					if err != nil {
+						return err
+					}
+					printDaemonStatus(status)
+					return nil
+				},
+			},
+			{
+				Name:  "disconnect",
+				Usage: "stops a background connection and clears the local ports from the environment file",
+				Flags: []cli.Flag{environmentFlag},
+				Action: func(c *cli.Context) error {
+					environmentPath := c.String("environment")
+					if err := environment.StopConnectDaemon(environmentPath); err != nil {
+						return err
+					}
+					var namespace string
+					err := environment.UpdateConfigFile(environmentPath, func(config *environment.Config) error {
+						config.ClearLocalPorts()
+						namespace = config.Namespace
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+					log.Info().Str("Namespace", namespace).Msg("Disconnected from environment")
+					return nil
+				},
+			},
 			{
 				Name:    "remove",
 				Aliases: []string{"rm"},
@@ -102,6 +452,11 @@ func main() {
 				Flags:   []cli.Flag{environmentFlag},
 				Action: func(c *cli.Context) error {
 					environmentPath := c.String("environment")
+					unlock, err := lockEnvironment(environmentPath)
+					if err != nil {
+						return err
+					}
+					defer unlock()
 					e, err := environment.DeployOrLoadEnvironmentFromConfigFile(environmentPath)
 					if err != nil {
 						return err
@@ -151,6 +506,104 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name:      "migrate",
+				ArgsUsage: "<environment file>...",
+				Usage:     "rewrites environment files in the current schema version, keeping the originals as .bak files",
+				Action: func(c *cli.Context) error {
+					if c.NArg() == 0 {
+						return fmt.Errorf("no environment file to migrate")
+					}
+					for _, path := range c.Args().Slice() {
+						version, err := environment.MigrateConfigFile(path)
+						if err != nil {
+							return err
+						}
+						if version == environment.CurrentSchemaVersion {
+							log.Info().Str("Path", path).Int("Version", version).Msg("Environment file is up to date")
+							continue
+						}
+						log.Info().
+							Str("Path", path).
+							Int("From", version).
+							Int("To", environment.CurrentSchemaVersion).
+							Str("Backup", path+".bak").
+							Msg("Environment file migrated")
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "cache",
+				Usage: "manages the cache of downloaded charts",
+				Subcommands: []*cli.Command{
+					{
+						Name:    "list",
+						Aliases: []string{"ls"},
+						Usage:   "lists the cached charts",
+						Action: func(c *cli.Context) error {
+							cache, err := environment.DefaultChartCache()
+							if err != nil {
+								return err
+							}
+							entries, err := cache.List()
+							if err != nil {
+								return err
+							}
+							w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+							fmt.Fprintln(w, "SOURCE\tSHA256\tSIZE\tFETCHED")
+							for _, entry := range entries {
+								fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", entry.Source, entry.Digest, entry.Size, entry.FetchedAt.Format(time.RFC3339))
+							}
+							return w.Flush()
+						},
+					},
+					{
+						Name:  "verify",
+						Usage: "checks the cached charts against their digests",
+						Action: func(c *cli.Context) error {
+							cache, err := environment.DefaultChartCache()
+							if err != nil {
+								return err
+							}
+							corrupted, err := cache.Verify()
+							if err != nil {
+								return err
+							}
+							for _, entry := range corrupted {
+								log.Error().Str("Source", entry.Source).Str("SHA256", entry.Digest).Msg("Cached chart is missing or corrupted")
+							}
+							if len(corrupted) > 0 {
+								return fmt.Errorf("%d cached charts are missing or corrupted, run `envcli cache prune` to remove them", len(corrupted))
+							}
+							log.Info().Msg("All cached charts match their digests")
+							return nil
+						},
+					},
+					{
+						Name:  "prune",
+						Usage: "removes corrupted and unreferenced charts from the cache",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "all",
+								Usage: "removes every cached chart",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							cache, err := environment.DefaultChartCache()
+							if err != nil {
+								return err
+							}
+							removed, err := cache.Prune(c.Bool("all"))
+							if err != nil {
+								return err
+							}
+							log.Info().Int("Removed", removed).Msg("Chart cache pruned")
+							return nil
+						},
+					},
+				},
+			},
 			{
 				Name:    "chaos",
 				Aliases: []string{"ch"},
@@ -171,6 +624,11 @@ func main() {
 						Action: func(c *cli.Context) error {
 							environmentPath := c.String("environment")
 							chaosTemplate := c.String("template")
+							unlock, err := lockEnvironment(environmentPath)
+							if err != nil {
+								return err
+							}
+							defer unlock()
 							e, err := environment.DeployOrLoadEnvironmentFromConfigFile(environmentPath)
 							if err != nil {
 								return err
@@ -197,6 +655,11 @@ func main() {
 						Action: func(c *cli.Context) error {
 							environmentPath := c.String("environment")
 							chaosID := c.String("chaos_id")
+							unlock, err := lockEnvironment(environmentPath)
+							if err != nil {
+								return err
+							}
+							defer unlock()
 							e, err := environment.DeployOrLoadEnvironmentFromConfigFile(environmentPath)
 							if err != nil {
 								return err
@@ -218,6 +681,11 @@ func main() {
 						Flags:   []cli.Flag{environmentFlag},
 						Action: func(c *cli.Context) error {
 							environmentPath := c.String("environment")
+							unlock, err := lockEnvironment(environmentPath)
+							if err != nil {
+								return err
+							}
+							defer unlock()
 							e, err := environment.DeployOrLoadEnvironmentFromConfigFile(environmentPath)
 							if err != nil {
 								return err
@@ -0,0 +1,326 @@
+package environment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	chartCacheDir = "cache"
+	blobsDir      = "blobs"
+	refsDir       = "refs"
+)
+
+// ChartCache keeps downloaded chart archives by the sha256 digest of their content, the source they were fetched from
+// refers to the digest, so sources sharing a file name never collide and a truncated download is never trusted
+type ChartCache struct {
+	Dir string
+}
+
+// ChartCacheEntry a source of a cached chart and the digest of its archive
+type ChartCacheEntry struct {
+	Source    string    `json:"source"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// DefaultChartCache returns the cache in ~/.helmenv/cache
+func DefaultChartCache() (*ChartCache, error) {
+	dir, err := helmenvDir()
+	if err != nil {
+		return nil, err
+	}
+	return &ChartCache{Dir: filepath.Join(dir, chartCacheDir)}, nil
+}
+
+// BlobPath returns the path of the archive with a digest
+func (c *ChartCache) BlobPath(digest string) string {
+	return filepath.Join(c.Dir, blobsDir, digest+".tgz")
+}
+
+// Lookup returns the path of the cached archive of a source, pinned to a digest if one is given, a cached archive
+// whose content no longer matches its digest is treated as missing
+func (c *ChartCache) Lookup(source string, digest string) (string, bool) {
+	// digests are stored lowercase, pins may be written in any case
+	digest = strings.ToLower(digest)
+	if digest == "" {
+		entry, err := c.entry(source)
+		if err != nil {
+			return "", false
+		}
+		digest = entry.Digest
+	}
+	path := c.BlobPath(digest)
+	actual, err := fileDigest(path)
+	if err != nil || actual != digest {
+		return "", false
+	}
+	return path, true
+}
+
+// Put stores the archive of a source, it fails if the archive doesn't match a pinned digest
+func (c *ChartCache) Put(source string, data []byte, pinnedDigest string) (string, string, error) {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	if pinnedDigest = strings.ToLower(pinnedDigest); pinnedDigest != "" && pinnedDigest != digest {
+		return "", "", fmt.Errorf("chart %s has sha256 %s, expected %s", source, digest, pinnedDigest)
+	}
+	path := c.BlobPath(digest)
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return "", "", err
+	}
+	entry := &ChartCacheEntry{Source: source, Digest: digest, Size: int64(len(data)), FetchedAt: time.Now().UTC()}
+	entryData, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return "", "", err
+	}
+	if err := writeFileAtomic(c.refPath(source), entryData, 0644); err != nil {
+		return "", "", err
+	}
+	return path, digest, nil
+}
+
+// List returns the cached sources ordered by source
+func (c *ChartCache) List() ([]*ChartCacheEntry, error) {
+	files, err := os.ReadDir(filepath.Join(c.Dir, refsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*ChartCacheEntry
+	for _, f := range files {
+		entry, err := readCacheEntry(filepath.Join(c.Dir, refsDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Source < entries[j].Source
+	})
+	return entries, nil
+}
+
+// Verify returns the sources whose archive is missing or doesn't match its digest
+func (c *ChartCache) Verify() ([]*ChartCacheEntry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	var corrupted []*ChartCacheEntry
+	for _, entry := range entries {
+		if _, ok := c.Lookup(entry.Source, entry.Digest); !ok {
+			corrupted = append(corrupted, entry)
+		}
+	}
+	return corrupted, nil
+}
+
+// Prune removes sources whose archive is missing or corrupted and archives no source refers to, or everything if all
+// is set, it returns the number of removed archives
+func (c *ChartCache) Prune(all bool) (int, error) {
+	entries, err := c.List()
+	if err != nil {
+		return 0, err
+	}
+	referenced := map[string]bool{}
+	for _, entry := range entries {
+		if _, ok := c.Lookup(entry.Source, entry.Digest); ok && !all {
+			referenced[entry.Digest] = true
+			continue
+		}
+		if err := os.Remove(c.refPath(entry.Source)); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+	blobs, err := os.ReadDir(filepath.Join(c.Dir, blobsDir))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, blob := range blobs {
+		if referenced[strings.TrimSuffix(strings.TrimSuffix(blob.Name(), provenanceExtension), ".tgz")] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, blobsDir, blob.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func (c *ChartCache) entry(source string) (*ChartCacheEntry, error) {
+	return readCacheEntry(c.refPath(source))
+}
+
+// refPath returns the path of the entry of a source, named after the digest of the source
+func (c *ChartCache) refPath(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(c.Dir, refsDir, hex.EncodeToString(sum[:])+".json")
+}
+
+func readCacheEntry(path string) (*ChartCacheEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entry := &ChartCacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, errors.Wrapf(err, "failed to read chart cache entry %s", path)
+	}
+	return entry, nil
+}
+
+// chartSource an archive of a chart that can be fetched, along with the provenance file signing it under its file name
+type chartSource struct {
+	id              string
+	fileName        string
+	fetch           func() ([]byte, error)
+	fetchProvenance func() ([]byte, error)
+}
+
+// fetchCachedChart points the chart path at the cached archive of a source, fetching it if it isn't cached yet, and
+// verifies its provenance if required. Offline environments never fetch
+func (hc *HelmChart) fetchCachedChart(source chartSource) error {
+	cache, err := DefaultChartCache()
+	if err != nil {
+		return err
+	}
+	if path, ok := cache.Lookup(source.id, hc.SHA256); ok {
+		log.Debug().Str("Source", source.id).Str("Path", path).Msg("Chart found in cache")
+		hc.Path = path
+		return hc.verifyChart(source)
+	}
+	if hc.offline() || source.fetch == nil {
+		return fmt.Errorf("chart %s isn't cached and the environment is offline", source.id)
+	}
+	data, err := source.fetch()
+	if err != nil {
+		return err
+	}
+	path, digest, err := cache.Put(source.id, data, hc.SHA256)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("Source", source.id).Str("SHA256", digest).Msg("Chart cached")
+	hc.Path = path
+	return hc.verifyChart(source)
+}
+
+func (hc *HelmChart) offline() bool {
+	return hc.env != nil && hc.env.Config.Offline
+}
+
+// downloadChart downloads the chart archive at the URL of the chart into the cache
+func (hc *HelmChart) downloadChart() error {
+	chartURL, err := url.Parse(hc.URL)
+	if err != nil {
+		return errors.Wrapf(err, "invalid chart URL %s", hc.URL)
+	}
+	return hc.fetchCachedChart(chartSource{
+		id:       hc.URL,
+		fileName: path.Base(chartURL.Path),
+		fetch: func() ([]byte, error) {
+			log.Info().Str("URL", hc.URL).Msg("Downloading Helm chart")
+			return httpGet(hc.URL)
+		},
+		fetchProvenance: func() ([]byte, error) {
+			return httpGet(hc.URL + ".prov")
+		},
+	})
+}
+
+// httpGet downloads a file
+func httpGet(fileURL string) ([]byte, error) {
+	resp, err := http.Get(fileURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", fileURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: GET returned %s", fileURL, resp.Status)
+	}
+	return readFull(resp)
+}
+
+// readFull reads a response body, failing if it's shorter than its content length
+func readFull(resp *http.Response) ([]byte, error) {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength >= 0 && int64(len(data)) != resp.ContentLength {
+		return nil, fmt.Errorf("download of %s is truncated, got %d of %d bytes", resp.Request.URL, len(data), resp.ContentLength)
+	}
+	return data, nil
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeFileAtomic writes a file through a temporary file in the same directory, so readers never see a partial file,
+// the file and its directory are synced so a crash doesn't leave an empty file in place of the previous one
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
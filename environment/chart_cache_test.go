@@ -0,0 +1,89 @@
+package environment_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChartCache(t *testing.T) {
+	t.Parallel()
+	cache := &environment.ChartCache{Dir: t.TempDir()}
+	data := []byte("chart archive")
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	_, _, err := cache.Put("https://a.example/chart.tgz", data, "deadbeef")
+	require.Error(t, err)
+	path, putDigest, err := cache.Put("https://a.example/chart.tgz", data, strings.ToUpper(digest))
+	require.NoError(t, err)
+	require.Equal(t, digest, putDigest)
+	_, _, err = cache.Put("https://b.example/chart.tgz", []byte("another chart"), "")
+	require.NoError(t, err)
+
+	cached, ok := cache.Lookup("https://a.example/chart.tgz", "")
+	require.True(t, ok)
+	require.Equal(t, path, cached)
+	cached, ok = cache.Lookup("https://a.example/chart.tgz", strings.ToUpper(digest))
+	require.True(t, ok, "pins are compared case insensitively")
+	require.Equal(t, path, cached)
+	_, ok = cache.Lookup("https://c.example/chart.tgz", "")
+	require.False(t, ok)
+	entries, err := cache.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	require.NoError(t, os.WriteFile(path, data[:5], 0644))
+	_, ok = cache.Lookup("https://a.example/chart.tgz", digest)
+	require.False(t, ok)
+	corrupted, err := cache.Verify()
+	require.NoError(t, err)
+	require.Len(t, corrupted, 1)
+	require.Equal(t, "https://a.example/chart.tgz", corrupted[0].Source)
+
+	removed, err := cache.Prune(false)
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	entries, err = cache.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	removed, err = cache.Prune(true)
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+}
+
+func TestFetchURLChart(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	data := []byte("chart archive")
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+	sum := sha256.Sum256(data)
+
+	for _, u := range []string{server.URL + "/a/chart.tgz", server.URL + "/b/chart.tgz"} {
+		hc := &environment.HelmChart{ReleaseName: "chart", URL: u, SHA256: hex.EncodeToString(sum[:])}
+		require.NoError(t, hc.FetchChart())
+		require.Equal(t, hex.EncodeToString(sum[:])+".tgz", filepath.Base(hc.Path))
+		require.NoError(t, hc.FetchChart())
+	}
+	require.Equal(t, 1, requests, "pinned charts are looked up by digest")
+
+	hc := &environment.HelmChart{ReleaseName: "chart", URL: server.URL + "/d/chart.tgz", SHA256: strings.ToUpper(hex.EncodeToString(sum[:]))}
+	require.NoError(t, hc.FetchChart())
+	require.Equal(t, hex.EncodeToString(sum[:])+".tgz", filepath.Base(hc.Path))
+	require.Equal(t, 1, requests, "uppercase pins are looked up like lowercase ones")
+
+	hc = &environment.HelmChart{ReleaseName: "chart", URL: server.URL + "/c/chart.tgz", SHA256: "deadbeef"}
+	require.Error(t, hc.FetchChart())
+}
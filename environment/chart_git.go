@@ -0,0 +1,176 @@
+package environment
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	gitCacheDir     = "git"
+	gitMirrorsDir   = "mirrors"
+	gitCheckoutsDir = "checkouts"
+)
+
+var commitSHARegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// fetchGitChart mirrors the git repository of the chart into ~/.helmenv/git, resolves the ref to a commit and points
+// the chart path at the subpath of a checkout of that commit. Offline environments resolve the ref in the mirror
+func (hc *HelmChart) fetchGitChart() error {
+	dir, err := helmenvDir()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(hc.GitRepository))
+	mirror := filepath.Join(dir, gitCacheDir, gitMirrorsDir, hex.EncodeToString(sum[:])+".git")
+	ref := hc.GitRef
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if strings.HasPrefix(ref, "-") {
+		// git would take the ref for an option
+		return fmt.Errorf("git ref %s of %s can't start with -", ref, hc.GitRepository)
+	}
+	if _, err := os.Stat(mirror); os.IsNotExist(err) {
+		if hc.offline() {
+			return fmt.Errorf("git repository %s isn't cached and the environment is offline", hc.GitRepository)
+		}
+		log.Info().Str("Repository", hc.GitRepository).Msg("Cloning chart git repository")
+		if _, err := runGit("", "clone", "--mirror", "--quiet", "--", hc.GitRepository, mirror); err != nil {
+			return errors.Wrapf(err, "failed to clone %s", hc.GitRepository)
+		}
+	} else if !hc.offline() && !(commitSHARegexp.MatchString(ref) && hasCommit(mirror, ref)) {
+		log.Debug().Str("Repository", hc.GitRepository).Msg("Fetching chart git repository")
+		if _, err := runGit(mirror, "fetch", "--prune", "--quiet", "origin"); err != nil {
+			return errors.Wrapf(err, "failed to fetch %s", hc.GitRepository)
+		}
+	}
+	commit, err := runGit(mirror, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("failed to resolve ref %s of %s", ref, hc.GitRepository)
+	}
+	checkout := filepath.Join(dir, gitCacheDir, gitCheckoutsDir, commit)
+	if _, err := os.Stat(checkout); os.IsNotExist(err) {
+		if err := extractCommit(mirror, commit, checkout); err != nil {
+			return errors.Wrapf(err, "failed to check out %s of %s", commit, hc.GitRepository)
+		}
+	}
+	chartPath := filepath.Join(checkout, filepath.FromSlash(hc.GitSubpath))
+	if rel, err := filepath.Rel(checkout, chartPath); err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("subpath %s is outside of git repository %s", hc.GitSubpath, hc.GitRepository)
+	}
+	if _, err := os.Stat(chartPath); err != nil {
+		return fmt.Errorf("subpath %s doesn't exist at %s of %s", hc.GitSubpath, commit, hc.GitRepository)
+	}
+	log.Info().Str("Repository", hc.GitRepository).Str("Ref", ref).Str("Commit", commit).Msg("Chart resolved from git")
+	hc.ResolvedCommit = commit
+	hc.Path = chartPath
+	return nil
+}
+
+// extractCommit writes the tree of a commit into a directory, the directory only appears once it's complete
+func extractCommit(mirror string, commit string, dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), "."+commit+".*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	cmd := exec.Command("git", "--git-dir", mirror, "archive", "--format=tar", commit)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := untar(out, tmp); err != nil {
+		_ = cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return os.Rename(tmp, dir)
+}
+
+func untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if rel, err := filepath.Rel(dir, target); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("archive entry %s is outside of the checkout", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&0755|0644)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				_ = f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			linked := filepath.Join(filepath.Dir(target), filepath.FromSlash(header.Linkname))
+			if rel, err := filepath.Rel(dir, linked); filepath.IsAbs(header.Linkname) || err != nil || strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("archive entry %s links to %s, outside of the checkout", header.Name, header.Linkname)
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func hasCommit(mirror string, commit string) bool {
+	_, err := runGit(mirror, "cat-file", "-e", commit+"^{commit}")
+	return err == nil
+}
+
+// runGit runs a git command, in a repository if one is given, and returns its trimmed output
+func runGit(gitDir string, args ...string) (string, error) {
+	if gitDir != "" {
+		args = append([]string{"--git-dir", gitDir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
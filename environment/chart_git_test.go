@@ -0,0 +1,95 @@
+package environment_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=helmenv", "-c", "user.email=helmenv@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// gitChartRepository creates a bare repository with a chart in charts/app, tagged v1 at version 1.0.0 and at version
+// 2.0.0 on the main branch, it returns the repository and the commit of the tag
+func gitChartRepository(t *testing.T) (string, string) {
+	work, bare := t.TempDir(), t.TempDir()
+	git(t, bare, "init", "--quiet", "--bare")
+	git(t, work, "init", "--quiet", "--initial-branch", "main")
+	chartDir := filepath.Join(work, "charts", "app")
+	require.NoError(t, os.MkdirAll(chartDir, 0755))
+	var tagged string
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		chartYAML := "apiVersion: v2\nname: app\nversion: " + version + "\n"
+		require.NoError(t, os.WriteFile(filepath.Join(chartDir, "Chart.yaml"), []byte(chartYAML), 0644))
+		git(t, work, "add", ".")
+		git(t, work, "commit", "--quiet", "-m", version)
+		if tagged == "" {
+			git(t, work, "tag", "v1")
+			tagged = git(t, work, "rev-parse", "HEAD")
+		}
+	}
+	git(t, work, "push", "--quiet", "--tags", bare, "main")
+	return bare, tagged
+}
+
+func TestFetchGitChart(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	repo, tagged := gitChartRepository(t)
+
+	hc := &environment.HelmChart{ReleaseName: "app", GitRepository: repo, GitRef: "v1", GitSubpath: "charts/app"}
+	require.NoError(t, hc.FetchChart())
+	require.Equal(t, tagged, hc.ResolvedCommit)
+	loaded, err := loader.Load(hc.Path)
+	require.NoError(t, err)
+	require.Equal(t, "1.0.0", loaded.Metadata.Version)
+
+	hc.GitRef = "main"
+	require.NoError(t, hc.FetchChart())
+	require.NotEqual(t, tagged, hc.ResolvedCommit)
+	loaded, err = loader.Load(hc.Path)
+	require.NoError(t, err)
+	require.Equal(t, "2.0.0", loaded.Metadata.Version)
+
+	hc.GitRef = tagged
+	require.NoError(t, hc.FetchChart())
+	require.Equal(t, tagged, hc.ResolvedCommit)
+
+	hc.GitRef = "missing"
+	require.Error(t, hc.FetchChart())
+	hc.GitRef = "main"
+	hc.GitSubpath = "../outside"
+	require.Error(t, hc.FetchChart())
+}
+
+func TestFetchGitChartUnsafe(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	repo, _ := gitChartRepository(t)
+
+	hc := &environment.HelmChart{ReleaseName: "app", GitRepository: repo, GitRef: "--output=/tmp/pwned", GitSubpath: "charts/app"}
+	require.EqualError(t, hc.FetchChart(), "git ref --output=/tmp/pwned of "+repo+" can't start with -")
+
+	work := t.TempDir()
+	git(t, work, "clone", "--quiet", repo, ".")
+	for name, target := range map[string]string{"escaping": "../../../outside", "absolute": "/etc/passwd"} {
+		git(t, work, "checkout", "--quiet", "-b", name, "origin/main")
+		require.NoError(t, os.Symlink(target, filepath.Join(work, "charts", "app", "link")))
+		git(t, work, "add", ".")
+		git(t, work, "commit", "--quiet", "-m", name)
+		git(t, work, "push", "--quiet", "origin", name)
+
+		hc := &environment.HelmChart{ReleaseName: "app", GitRepository: repo, GitRef: name, GitSubpath: "charts/app"}
+		require.ErrorContains(t, hc.FetchChart(), "archive entry charts/app/link links to "+target+", outside of the checkout", name)
+		require.NoError(t, os.Remove(filepath.Join(work, "charts", "app", "link")))
+	}
+}
@@ -0,0 +1,102 @@
+package environment
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"helm.sh/helm/v3/pkg/provenance"
+)
+
+const provenanceExtension = ".prov"
+
+// verificationRequired returns true if the chart or the whole environment requires signed charts
+func (hc *HelmChart) verificationRequired() bool {
+	return hc.Verify || (hc.env != nil && hc.env.Config.VerifyCharts)
+}
+
+// keyring resolves the keyring of the chart, falling back to the one of the environment and then to the default GnuPG
+// public keyring like Helm does
+func (hc *HelmChart) keyring() (string, error) {
+	if hc.Keyring != "" {
+		return hc.Keyring, nil
+	}
+	if hc.env != nil && hc.env.Config.Keyring != "" {
+		return hc.env.Config.Keyring, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".gnupg", "pubring.gpg"), nil
+}
+
+// verifyChart checks the cached archive of the chart against its provenance file and records the signer, charts
+// without a provenance file or signed by a key outside the keyring are refused
+func (hc *HelmChart) verifyChart(source chartSource) error {
+	if !hc.verificationRequired() {
+		return nil
+	}
+	provPath := hc.Path + provenanceExtension
+	if _, err := os.Stat(provPath); err != nil {
+		if hc.offline() {
+			return fmt.Errorf("provenance of chart %s isn't cached and the environment is offline", source.id)
+		}
+		if source.fetchProvenance == nil {
+			return fmt.Errorf("chart %s can't be verified, its source has no provenance files", source.id)
+		}
+		data, err := source.fetchProvenance()
+		if err != nil {
+			return errors.Wrapf(err, "chart %s isn't signed", source.id)
+		}
+		if err := writeFileAtomic(provPath, data, 0644); err != nil {
+			return err
+		}
+	}
+	keyring, err := hc.keyring()
+	if err != nil {
+		return err
+	}
+	signatory, err := provenance.NewFromKeyring(keyring, "")
+	if err != nil {
+		return errors.Wrapf(err, "failed to load keyring %s", keyring)
+	}
+	// the provenance file signs the archive by its file name, cached archives are named by digest
+	dir, err := os.MkdirTemp("", "helmenv-verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, source.fileName)
+	if err := os.Symlink(hc.Path, archive); err != nil {
+		return err
+	}
+	verification, err := signatory.Verify(archive, provPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to verify chart %s", source.id)
+	}
+	// the primary identity of the key names the signer, or the first of its identities by name if none is primary
+	var names []string
+	for name, identity := range verification.SignedBy.Identities {
+		if identity.SelfSignature != nil && identity.SelfSignature.IsPrimaryId != nil && *identity.SelfSignature.IsPrimaryId {
+			names = []string{name}
+			break
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hc.VerifiedSigner = ""
+	if len(names) > 0 {
+		hc.VerifiedSigner = names[0]
+	}
+	log.Info().
+		Str("Source", source.id).
+		Str("Signer", hc.VerifiedSigner).
+		Str("Fingerprint", fmt.Sprintf("%X", verification.SignedBy.PrimaryKey.Fingerprint)).
+		Str("Hash", verification.FileHash).
+		Msg("Chart verified")
+	return nil
+}
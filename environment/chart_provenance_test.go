@@ -0,0 +1,77 @@
+package environment_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp" //nolint
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+)
+
+// signedCharts serves a signed chart, an unsigned chart and a chart whose provenance signs another archive, it returns
+// the keyring holding the public key of the signer
+func signedCharts(t *testing.T) (*httptest.Server, string) {
+	dir := t.TempDir()
+	entity, err := openpgp.NewEntity("helmenv", "", "helmenv@example.com", nil)
+	require.NoError(t, err)
+	keyring, err := os.Create(filepath.Join(dir, "pubring.gpg"))
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(keyring))
+	require.NoError(t, keyring.Close())
+
+	signatory := &provenance.Signatory{Entity: entity}
+	for _, name := range []string{"signed", "unsigned", "tampered"} {
+		archive, err := chartutil.Save(&chart.Chart{Metadata: &chart.Metadata{
+			APIVersion: chart.APIVersionV2,
+			Name:       name,
+			Version:    "1.0.0",
+		}}, dir)
+		require.NoError(t, err)
+		if name == "unsigned" {
+			continue
+		}
+		prov, err := signatory.ClearSign(archive)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(archive+".prov", []byte(prov), 0644))
+	}
+	tampered := filepath.Join(dir, "tampered-1.0.0.tgz")
+	data, err := os.ReadFile(filepath.Join(dir, "unsigned-1.0.0.tgz"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(tampered, data, 0644))
+	return httptest.NewServer(http.FileServer(http.Dir(dir))), keyring.Name()
+}
+
+func TestVerifyChartProvenance(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	server, keyring := signedCharts(t)
+	defer server.Close()
+
+	hc := &environment.HelmChart{ReleaseName: "signed", URL: server.URL + "/signed-1.0.0.tgz", Verify: true, Keyring: keyring}
+	require.NoError(t, hc.FetchChart())
+	require.Equal(t, "helmenv <helmenv@example.com>", hc.VerifiedSigner)
+	require.FileExists(t, hc.Path+".prov")
+
+	for _, name := range []string{"unsigned", "tampered"} {
+		hc := &environment.HelmChart{ReleaseName: name, URL: server.URL + "/" + name + "-1.0.0.tgz", Verify: true, Keyring: keyring}
+		require.Error(t, hc.FetchChart(), name)
+		require.Empty(t, hc.VerifiedSigner)
+	}
+
+	hc = &environment.HelmChart{ReleaseName: "unsigned", URL: server.URL + "/unsigned-1.0.0.tgz"}
+	require.NoError(t, hc.FetchChart())
+	require.Empty(t, hc.VerifiedSigner)
+	for _, hc := range []*environment.HelmChart{
+		{ReleaseName: "path", Path: "charts/geth", Verify: true},
+		{ReleaseName: "embedded", Verify: true},
+		{ReleaseName: "git", GitRepository: "https://github.com/smartcontractkit/helmenv.git", Verify: true},
+	} {
+		require.ErrorContains(t, hc.FetchChart(), "only charts from a repository, registry or URL can be verified", hc.ReleaseName)
+	}
+}
@@ -0,0 +1,389 @@
+package environment
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// Values of a chart can reference other charts of the environment with templates, resolved when the chart is deployed:
+//
+//	{{ connection "geth" "geth" 0 "ws-rpc" "ws" }}  in-cluster URL of a port, built by a registered URL builder
+//	{{ value "geth" "geth.networkId" }}            value given to another chart
+//	{{ secret "chainlink-db" "password" }}         field of a Secret in the environment namespace
+//
+// Referenced charts must be deployed at a lower index than the chart referencing them
+const referenceDelimiter = "{{"
+
+// referenceCallRegexp matches an action calling a reference function, templates that fail to parse are only errors
+// if they look like references, other ones are left to the chart
+var referenceCallRegexp = regexp.MustCompile(`\{\{-?\s*\(?\s*(connection|value|secret)\b`)
+
+// ResolveValues returns the values given to a chart with the references to other charts resolved, the values read
+// from secrets are redacted by RedactValues of the chart
+func (c Charts) ResolveValues(chartName string) (map[string]interface{}, error) {
+	chart, err := c.lookup(chartName)
+	if err != nil {
+		return nil, err
+	}
+	values, secretPaths, err := c.resolveValues(chart, nil)
+	if err != nil {
+		return nil, err
+	}
+	chart.templatePaths = secretPaths
+	return values, nil
+}
+
+// CheckReferences returns an error if charts reference charts that don't exist, reference each other in a cycle or
+// reference charts that aren't deployed before them
+func (c Charts) CheckReferences() error {
+	references, err := c.References()
+	if err != nil {
+		return err
+	}
+	if cycle := referenceCycle(references); cycle != nil {
+		return fmt.Errorf("charts reference each other in a cycle: %s", strings.Join(cycle, " -> "))
+	}
+	for _, name := range sortedKeys(references) {
+		for _, referenced := range references[name] {
+			chart, err := c.lookup(referenced)
+			if err != nil {
+				return errors.Wrapf(err, "chart %s references an unknown chart", name)
+			}
+			if chart.Index >= c[name].Index {
+				return fmt.Errorf(
+					"chart %s (index %d) references chart %s (index %d) which isn't deployed before it",
+					name, c[name].Index, referenced, chart.Index,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// References returns the charts each chart references in its values, ordered by name
+func (c Charts) References() (map[string][]string, error) {
+	references := map[string][]string{}
+	for name, chart := range c {
+		values, err := chart.OverrideValues()
+		if err != nil {
+			return nil, err
+		}
+		referenced := map[string]bool{}
+		record := func(chart string, _ ...interface{}) string {
+			referenced[chart] = true
+			return ""
+		}
+		funcs := template.FuncMap{
+			"connection": record,
+			"value":      record,
+			"secret":     func(...interface{}) string { return "" },
+		}
+		if _, err := resolveTemplates(values, "", funcs, nil); err != nil {
+			return nil, errors.Wrapf(err, "invalid reference in values of chart %s", name)
+		}
+		for chartName := range referenced {
+			references[name] = append(references[name], chartName)
+		}
+		sort.Strings(references[name])
+	}
+	return references, nil
+}
+
+// resolveValues resolves the references in the values of a chart and returns the paths of the values read from
+// secrets, directly or through the values of other charts. Resolving is the chain of charts whose values are being
+// resolved, to catch charts referencing each other's values
+func (c Charts) resolveValues(hc *HelmChart, resolving []string) (map[string]interface{}, []string, error) {
+	for i, name := range resolving {
+		if name == hc.ReleaseName {
+			return nil, nil, fmt.Errorf("charts reference each other in a cycle: %s", strings.Join(append(resolving[i:], name), " -> "))
+		}
+	}
+	resolving = append(resolving, hc.ReleaseName)
+	values, err := hc.OverrideValues()
+	if err != nil {
+		return nil, nil, err
+	}
+	// set by the value function when the value it returns holds a secret of the referenced chart
+	secretValue := false
+	funcs := template.FuncMap{
+		"connection": func(chart string, app string, instance int, portName string, urlBuilder string) (string, error) {
+			builder, err := c.urlBuilder(hc, urlBuilder)
+			if err != nil {
+				return "", err
+			}
+			u, err := c.Query().Chart(chart).App(app).Instance(instance).Port(portName).RemoteURLWith(builder)
+			if err != nil {
+				return "", errors.Wrapf(err, "is chart %s deployed before chart %s", chart, hc.ReleaseName)
+			}
+			return u.String(), nil
+		},
+		"value": func(chart string, path string) (interface{}, error) {
+			referenced, err := c.lookup(chart)
+			if err != nil {
+				return nil, err
+			}
+			values, secretPaths, err := c.resolveValues(referenced, resolving)
+			if err != nil {
+				return nil, err
+			}
+			value, ok := lookupValue(values, path)
+			if !ok {
+				return nil, fmt.Errorf("chart %s has no value %s", chart, path)
+			}
+			for _, secretPath := range secretPaths {
+				secretValue = secretValue || isRedactedPath(path, []string{secretPath}) || isRedactedPath(secretPath, []string{path})
+			}
+			return value, nil
+		},
+		"secret": func(name string, key string) (string, error) {
+			if hc.env == nil {
+				return "", fmt.Errorf("secret %s of chart %s can't be read outside of an environment", name, hc.ReleaseName)
+			}
+			return hc.env.GetSecretField(hc.env.Namespace, name, key)
+		},
+	}
+	var secretPaths []string
+	resolved, err := resolveTemplates(values, "", funcs, func(path string, name string) {
+		fromSecret := name == "secret" || (name == "value" && secretValue)
+		secretValue = false
+		if fromSecret && (len(secretPaths) == 0 || secretPaths[len(secretPaths)-1] != path) {
+			secretPaths = append(secretPaths, path)
+		}
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to resolve the values of chart %s", hc.ReleaseName)
+	}
+	return resolved.(map[string]interface{}), secretPaths, nil
+}
+
+// urlBuilder returns a registered URL builder, with credentials read from Secrets when the chart is deployed
+func (c Charts) urlBuilder(hc *HelmChart, name string) (*URLBuilder, error) {
+	if hc.env != nil {
+		return hc.env.URLBuilder(name)
+	}
+	return GetURLBuilder(name)
+}
+
+// resolveTemplates executes the templates in all the strings of values with the reference functions, called is told
+// the path of the value and the name of every function called to resolve it
+func resolveTemplates(value interface{}, path string, funcs template.FuncMap, called func(path string, name string)) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for _, key := range sortedKeys(v) {
+			itemPath := key
+			if path != "" {
+				itemPath = path + "." + key
+			}
+			r, err := resolveTemplates(v[key], itemPath, funcs, called)
+			if err != nil {
+				return nil, errors.Wrapf(err, "value %s", key)
+			}
+			resolved[key] = r
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i := range v {
+			r, err := resolveTemplates(v[i], fmt.Sprintf("%s[%d]", path, i), funcs, called)
+			if err != nil {
+				return nil, errors.Wrapf(err, "item %d", i)
+			}
+			resolved[i] = r
+		}
+		return resolved, nil
+	case string:
+		if !strings.Contains(v, referenceDelimiter) {
+			return v, nil
+		}
+		return resolveTemplate(v, path, funcs, called)
+	}
+	return value, nil
+}
+
+// resolveTemplate executes the template of a string, a string that is a single call of a reference function is
+// replaced by what the function returns so numbers, booleans and maps keep their type. Strings that don't call a
+// reference function are templates of the chart itself, e.g. for tpl, and are left as they are
+func resolveTemplate(value string, path string, funcs template.FuncMap, called func(path string, name string)) (interface{}, error) {
+	tree := parse.New("")
+	tree.Mode = parse.SkipFuncCheck
+	if _, err := tree.Parse(value, "", "", map[string]*parse.Tree{}); err != nil {
+		if referenceCallRegexp.MatchString(value) {
+			return nil, err
+		}
+		return value, nil
+	}
+	if !callsFuncs(tree.Root, funcs) {
+		return value, nil
+	}
+	var result interface{}
+	recording := make(template.FuncMap, len(funcs))
+	for name, fn := range funcs {
+		name := name
+		recording[name] = recordCall(fn, func(r interface{}) {
+			result = r
+			if called != nil {
+				called(path, name)
+			}
+		})
+	}
+	t, err := template.New("").Option("missingkey=error").Funcs(recording).Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, nil); err != nil {
+		return nil, err
+	}
+	if isSingleCall(t.Tree, funcs) {
+		return result, nil
+	}
+	return out.String(), nil
+}
+
+// callsFuncs returns true if one of the functions is called anywhere in the template node
+func callsFuncs(node parse.Node, funcs template.FuncMap) bool {
+	var nodes []parse.Node
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			nodes = n.Nodes
+		}
+	case *parse.ActionNode:
+		nodes = []parse.Node{n.Pipe}
+	case *parse.PipeNode:
+		if n != nil {
+			for _, cmd := range n.Cmds {
+				nodes = append(nodes, cmd)
+			}
+		}
+	case *parse.CommandNode:
+		nodes = n.Args
+	case *parse.ChainNode:
+		nodes = []parse.Node{n.Node}
+	case *parse.IfNode:
+		nodes = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.RangeNode:
+		nodes = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.WithNode:
+		nodes = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.TemplateNode:
+		nodes = []parse.Node{n.Pipe}
+	case *parse.IdentifierNode:
+		_, ok := funcs[n.Ident]
+		return ok
+	}
+	for _, child := range nodes {
+		if callsFuncs(child, funcs) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSingleCall returns true if the template is exactly one action whose pipeline ends with a call of one of the
+// functions, the call is the last one made when the template is executed
+func isSingleCall(tree *parse.Tree, funcs template.FuncMap) bool {
+	if tree == nil || len(tree.Root.Nodes) != 1 {
+		return false
+	}
+	action, ok := tree.Root.Nodes[0].(*parse.ActionNode)
+	if !ok || len(action.Pipe.Decl) != 0 || len(action.Pipe.Cmds) == 0 {
+		return false
+	}
+	last := action.Pipe.Cmds[len(action.Pipe.Cmds)-1]
+	identifier, ok := last.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return false
+	}
+	_, ok = funcs[identifier.Ident]
+	return ok
+}
+
+// recordCall wraps a template function to pass its result to record every time it's called
+func recordCall(fn interface{}, record func(result interface{})) interface{} {
+	f := reflect.ValueOf(fn)
+	return reflect.MakeFunc(f.Type(), func(args []reflect.Value) []reflect.Value {
+		var results []reflect.Value
+		if f.Type().IsVariadic() {
+			results = f.CallSlice(args)
+		} else {
+			results = f.Call(args)
+		}
+		if len(results) > 0 {
+			record(results[0].Interface())
+		}
+		return results
+	}).Interface()
+}
+
+// lookupValue returns a nested value by its dot separated path
+func lookupValue(values map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = values
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// referenceCycle returns the first cycle of charts referencing each other, starting and ending with the same chart
+func referenceCycle(references map[string][]string) []string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var stack []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			for i, n := range stack {
+				if n == name {
+					return append(append([]string{}, stack[i:]...), name)
+				}
+			}
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, referenced := range references[name] {
+			if cycle := visit(referenced); cycle != nil {
+				return cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, name := range sortedKeys(references) {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,118 @@
+package environment_test
+
+import (
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestChartReferences(t *testing.T) {
+	charts := queryTestCharts(t)
+	charts["geth"].ReleaseName = "geth"
+	charts["geth"].Index = 1
+	charts["geth"].Values = map[string]interface{}{"geth": map[string]interface{}{"networkId": 1337}}
+	charts["chainlink"].ReleaseName = "chainlink"
+	charts["chainlink"].Index = 2
+	charts["chainlink"].Values = map[string]interface{}{
+		"env": map[string]interface{}{
+			"eth_url":      `{{ connection "geth" "geth" 0 "ws-rpc" "ws" }}`,
+			"eth_chain_id": `{{ value "geth" "geth.networkId" }}`,
+			"eth_label":    `chain-{{ value "geth" "geth.networkId" }}`,
+			"release":      `{{ .Release.Name }}-{{ include "chainlink.fullname" . }}`,
+			"if_release":   `{{ if .Release.IsInstall }}install{{ end }}`,
+		},
+		"replicas": 2,
+	}
+	charts["chainlink"].Set = []string{`env.eth_http_url={{ connection "geth" "geth" 0 "http-rpc" "http" }}`}
+	require.NoError(t, charts.CheckReferences())
+
+	values, err := charts.ResolveValues("chainlink")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"env": map[string]interface{}{
+			"eth_url":      "ws://10.0.0.2:8546",
+			"eth_http_url": "http://10.0.0.2:8544",
+			"eth_chain_id": 1337,
+			"eth_label":    "chain-1337",
+			"release":      `{{ .Release.Name }}-{{ include "chainlink.fullname" . }}`,
+			"if_release":   `{{ if .Release.IsInstall }}install{{ end }}`,
+		},
+		"replicas": 2,
+	}, values)
+	require.Contains(t, charts["chainlink"].Values["env"], "eth_url", "the references are kept in the values")
+	require.Equal(t, `{{ connection "geth" "geth" 0 "ws-rpc" "ws" }}`, charts["chainlink"].Values["env"].(map[string]interface{})["eth_url"])
+
+	charts["chainlink"].Values["peer"] = `{{ connection "geth" "geth" 1 "ws-rpc" "ws" }}`
+	_, err = charts.ResolveValues("chainlink")
+	require.ErrorContains(t, err, "no connections found matching chart=geth, app=geth, instance=1, port=ws-rpc")
+	charts["chainlink"].Values["peer"] = `{{ value "geth" "geth.missing" }}`
+	_, err = charts.ResolveValues("chainlink")
+	require.ErrorContains(t, err, "chart geth has no value geth.missing")
+	delete(charts["chainlink"].Values, "peer")
+
+	charts["geth"].Values["peers"] = `{{ value "chainlink" "replicas" }}`
+	require.EqualError(t, charts.CheckReferences(), "charts reference each other in a cycle: chainlink -> geth -> chainlink")
+	_, err = charts.ResolveValues("chainlink")
+	require.ErrorContains(t, err, "charts reference each other in a cycle: chainlink -> geth -> chainlink")
+	delete(charts["geth"].Values, "peers")
+
+	charts["geth"].Index = 2
+	require.EqualError(t, charts.CheckReferences(), "chart chainlink (index 2) references chart geth (index 2) which isn't deployed before it")
+	charts["geth"].Index = 1
+	charts["chainlink"].Values["peer"] = `{{ value "geth" "geth.networkId" }`
+	require.ErrorContains(t, charts.CheckReferences(), "invalid reference in values of chart chainlink", "malformed references aren't left to the chart")
+	charts["chainlink"].Values["peer"] = `{{ value "missing" "replicas" }}`
+	require.ErrorContains(t, charts.CheckReferences(), "chart chainlink references an unknown chart")
+}
+
+func TestChartReferencesSecrets(t *testing.T) {
+	e := environment.NewEnvironmentWithClient(&environment.Config{Namespace: "env"}, fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{Name: "db", Namespace: "env"},
+		Data:       map[string][]byte{"password": []byte("node")},
+	}))
+	charts := queryTestCharts(t)
+	charts["chainlink"].ReleaseName = "chainlink"
+	charts["chainlink"].Index = 1
+	charts["chainlink"].Values = map[string]interface{}{
+		"db": map[string]interface{}{
+			"password": `{{ secret "db" "password" }}`,
+			"url":      `postgres://postgres:{{ secret "db" "password" }}@db:5432`,
+			"host":     "db",
+		},
+	}
+	require.NoError(t, e.AddChart(charts["chainlink"]))
+	charts["geth"].ReleaseName = "geth"
+	charts["geth"].Index = 2
+	charts["geth"].Values = map[string]interface{}{
+		"password": `{{ value "chainlink" "db.password" }}`,
+		"db":       `{{ value "chainlink" "db" }}`,
+		"host":     `{{ value "chainlink" "db.host" }}`,
+	}
+	require.NoError(t, e.AddChart(charts["geth"]))
+
+	values, err := charts.ResolveValues("chainlink")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"password": "node",
+		"url":      "postgres://postgres:node@db:5432",
+		"host":     "db",
+	}, values["db"])
+	require.Equal(t, map[string]interface{}{
+		"password": "[REDACTED]",
+		"url":      "[REDACTED]",
+		"host":     "db",
+	}, charts["chainlink"].RedactValues(values)["db"], "values resolved from secrets are redacted in logs")
+
+	gethValues, err := charts.ResolveValues("geth")
+	require.NoError(t, err)
+	require.Equal(t, "node", gethValues["password"])
+	require.Equal(t, map[string]interface{}{
+		"password": "[REDACTED]",
+		"db":       map[string]interface{}{"password": "[REDACTED]", "url": "[REDACTED]", "host": "[REDACTED]"},
+		"host":     "db",
+	}, charts["geth"].RedactValues(gethValues), "secrets of other charts are redacted as well")
+}
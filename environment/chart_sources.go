@@ -0,0 +1,309 @@
+package environment
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	// OCIScheme scheme of chart repositories that are OCI registries
+	OCIScheme = "oci"
+)
+
+// RepositoryAuth credentials of a chart repository or registry, either basic auth or a bearer token. The password and
+// token are never written to environment files, they are either set in code or read when the chart is fetched from
+// the references in password_from and token_from, e.g. ${env:REGISTRY_TOKEN}
+type RepositoryAuth struct {
+	Username     string `yaml:"username,omitempty" json:"username,omitempty" envconfig:"username"`
+	Password     string `yaml:"-" json:"-" envconfig:"password"`
+	Token        string `yaml:"-" json:"-" envconfig:"token"`
+	PasswordFrom string `yaml:"password_from,omitempty" json:"password_from,omitempty" envconfig:"password_from"`
+	TokenFrom    string `yaml:"token_from,omitempty" json:"token_from,omitempty" envconfig:"token_from"`
+}
+
+// repositoryAuth returns the credentials of the repository with the password and token read from their references
+// if they aren't set, nil if the repository has no credentials
+func (hc *HelmChart) repositoryAuth() (*RepositoryAuth, error) {
+	if hc.RepositoryAuth == nil {
+		return nil, nil
+	}
+	auth := *hc.RepositoryAuth
+	var err error
+	if auth.Password == "" && auth.PasswordFrom != "" {
+		if auth.Password, err = hc.resolveReferences(auth.PasswordFrom); err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve the repository password of chart %s", hc.ReleaseName)
+		}
+	}
+	if auth.Token == "" && auth.TokenFrom != "" {
+		if auth.Token, err = hc.resolveReferences(auth.TokenFrom); err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve the repository token of chart %s", hc.ReleaseName)
+		}
+	}
+	return &auth, nil
+}
+
+// helmenvDir returns the ~/.helmenv directory, creating it if it doesn't exist
+func helmenvDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(homeDir, ".helmenv")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create helmenv directory: %v", err)
+	}
+	return dir, nil
+}
+
+// FetchChart downloads the chart from its repository, registry, git repository or URL and points the chart path at
+// it, the version resolved from a repository or registry and the commit resolved from git are recorded on the chart
+func (hc *HelmChart) FetchChart() error {
+	// only archives from a repository, registry or URL come with a provenance file
+	verifiable := hc.GitRepository == "" && (hc.Repository != "" || hc.URL != "")
+	if hc.verificationRequired() && !verifiable {
+		return fmt.Errorf("chart of release %s requires verification but only charts from a repository, registry or URL can be verified", hc.ReleaseName)
+	}
+	switch {
+	case hc.GitRepository != "":
+		return hc.fetchGitChart()
+	case hc.Repository != "":
+		return hc.fetchRepositoryChart()
+	case hc.URL != "":
+		return hc.downloadChart()
+	}
+	return nil
+}
+
+func (hc *HelmChart) fetchRepositoryChart() error {
+	if hc.Chart == "" {
+		return fmt.Errorf("chart name of repository %s isn't set for release %s", hc.Repository, hc.ReleaseName)
+	}
+	if hc.offline() {
+		// offline environments can't resolve versions, the last resolved one has to be cached
+		if hc.ResolvedVersion == "" {
+			hc.ResolvedVersion = hc.Version
+		}
+		return hc.fetchCachedChart(chartSource{id: hc.repositorySource(), fileName: hc.archiveName()})
+	}
+	repoURL, err := url.Parse(hc.Repository)
+	if err != nil {
+		return errors.Wrapf(err, "invalid chart repository %s", hc.Repository)
+	}
+	if repoURL.Scheme == OCIScheme {
+		return hc.pullOCIChart(repoURL)
+	}
+	return hc.downloadRepositoryChart()
+}
+
+// downloadRepositoryChart resolves the chart version through the index of the repository and downloads it
+func (hc *HelmChart) downloadRepositoryChart() error {
+	indexURL := strings.TrimSuffix(hc.Repository, "/") + "/index.yaml"
+	data, err := hc.getRepositoryFile(indexURL)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get the index of chart repository %s", hc.Repository)
+	}
+	index := &repo.IndexFile{}
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return errors.Wrapf(err, "failed to read the index of chart repository %s", hc.Repository)
+	}
+	index.SortEntries()
+	chartVersion, err := index.Get(hc.Chart, hc.Version)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve chart %s %s in repository %s", hc.Chart, hc.Version, hc.Repository)
+	}
+	if len(chartVersion.URLs) == 0 {
+		return fmt.Errorf("chart %s %s in repository %s has no URLs", hc.Chart, chartVersion.Version, hc.Repository)
+	}
+	chartURL, err := repo.ResolveReferenceURL(hc.Repository, chartVersion.URLs[0])
+	if err != nil {
+		return err
+	}
+	hc.ResolvedVersion = chartVersion.Version
+	return hc.fetchCachedChart(chartSource{
+		id:       hc.repositorySource(),
+		fileName: path.Base(chartURL),
+		fetch: func() ([]byte, error) {
+			log.Info().
+				Str("Repository", hc.Repository).
+				Str("Chart", hc.Chart).
+				Str("Version", chartVersion.Version).
+				Msg("Downloading Helm chart from repository")
+			data, err := hc.getRepositoryFile(chartURL)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to download chart %s %s", hc.Chart, chartVersion.Version)
+			}
+			return data, nil
+		},
+		fetchProvenance: func() ([]byte, error) {
+			return hc.getRepositoryFile(chartURL + ".prov")
+		},
+	})
+}
+
+// getRepositoryFile gets a file of the repository, credentials are only sent to the host of the repository
+func (hc *HelmChart) getRepositoryFile(fileURL string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if repoURL, err := url.Parse(hc.Repository); err == nil && repoURL.Host == req.URL.Host && hc.RepositoryAuth != nil {
+		auth, err := hc.repositoryAuth()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case auth.Token != "":
+			req.Header.Set("Authorization", "Bearer "+auth.Token)
+		case auth.Username != "":
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %s", fileURL, resp.Status)
+	}
+	return readFull(resp)
+}
+
+// pullOCIChart resolves the chart version through the tags of the registry and pulls it. The credentials of the
+// registry are only on disk while the chart is pulled, registries without credentials use the ones of helm registry
+// login
+func (hc *HelmChart) pullOCIChart(repoURL *url.URL) error {
+	auth, err := hc.repositoryAuth()
+	if err != nil {
+		return err
+	}
+	var options []registry.ClientOption
+	if auth != nil {
+		credentialsFile, err := writeRegistryCredentials(repoURL.Host, auth)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := os.Remove(credentialsFile); err != nil {
+				log.Warn().Err(err).Str("Path", credentialsFile).Msg("Failed to remove registry credentials")
+			}
+		}()
+		options = append(options, registry.ClientOptCredentialsFile(credentialsFile))
+	}
+	client, err := registry.NewClient(options...)
+	if err != nil {
+		return err
+	}
+	ref := strings.TrimPrefix(strings.TrimSuffix(hc.Repository, "/"), OCIScheme+"://") + "/" + hc.Chart
+	tags, err := client.Tags(ref)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list the versions of chart %s", ref)
+	}
+	version, err := matchVersion(tags, hc.Version)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve chart %s %s", ref, hc.Version)
+	}
+	hc.ResolvedVersion = version
+	return hc.fetchCachedChart(chartSource{
+		id:       hc.repositorySource(),
+		fileName: hc.archiveName(),
+		fetch: func() ([]byte, error) {
+			log.Info().Str("Reference", ref).Str("Version", version).Msg("Pulling Helm chart from registry")
+			result, err := client.Pull(ref+":"+version, registry.PullOptWithChart(true))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to pull chart %s:%s", ref, version)
+			}
+			return result.Chart.Data, nil
+		},
+		fetchProvenance: func() ([]byte, error) {
+			result, err := client.Pull(ref+":"+version, registry.PullOptWithChart(false), registry.PullOptWithProv(true))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to pull the provenance of chart %s:%s", ref, version)
+			}
+			return result.Prov.Data, nil
+		},
+	})
+}
+
+// archiveName returns the file name Helm packages the resolved version of a repository chart as
+func (hc *HelmChart) archiveName() string {
+	return fmt.Sprintf("%s-%s.tgz", hc.Chart, hc.ResolvedVersion)
+}
+
+// repositorySource identifies the resolved version of a repository chart in the chart cache
+func (hc *HelmChart) repositorySource() string {
+	return fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(hc.Repository, "/"), hc.Chart, hc.ResolvedVersion)
+}
+
+// matchVersion returns the highest version matching a semver constraint, or the version itself if it's listed
+func matchVersion(versions []string, constraint string) (string, error) {
+	for _, v := range versions {
+		if v == constraint {
+			return v, nil
+		}
+	}
+	if constraint == "" {
+		constraint = "*"
+	}
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+	var matched *semver.Version
+	for _, v := range versions {
+		version, err := semver.NewVersion(v)
+		if err != nil || !c.Check(version) {
+			continue
+		}
+		if matched == nil || version.GreaterThan(matched) {
+			matched = version
+		}
+	}
+	if matched == nil {
+		return "", fmt.Errorf("no version matches %s", constraint)
+	}
+	return matched.Original(), nil
+}
+
+// writeRegistryCredentials stores the credentials of a registry host in a new docker config file only the user can
+// read and returns its path, tokens are stored as identity tokens. The caller removes the file
+func writeRegistryCredentials(host string, auth *RepositoryAuth) (string, error) {
+	dir, err := helmenvDir()
+	if err != nil {
+		return "", err
+	}
+	credentials := map[string]string{
+		"auth": base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password)),
+	}
+	if auth.Token != "" {
+		credentials = map[string]string{"identitytoken": auth.Token}
+	}
+	data, err := json.Marshal(map[string]interface{}{"auths": map[string]interface{}{host: credentials}})
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "registry-*.json")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
@@ -0,0 +1,103 @@
+package environment_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// chartRepository serves an index and archives of a chart in several versions behind basic auth
+func chartRepository(t *testing.T, name string, versions ...string) *httptest.Server {
+	dir := t.TempDir()
+	index := "apiVersion: v1\nentries:\n  " + name + ":\n"
+	for _, version := range versions {
+		archive, err := chartutil.Save(&chart.Chart{Metadata: &chart.Metadata{
+			APIVersion: chart.APIVersionV2,
+			Name:       name,
+			Version:    version,
+		}}, dir)
+		require.NoError(t, err)
+		index += fmt.Sprintf("  - name: %s\n    version: %s\n    urls: [%s]\n", name, version, filepath.Base(archive))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.yaml"), []byte(index), 0644))
+	files := http.FileServer(http.Dir(dir))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "helm" || password != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.StripPrefix("/charts", files).ServeHTTP(w, r)
+	}))
+}
+
+func TestFetchRepositoryChart(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	repo := chartRepository(t, "adapter", "1.2.0", "1.3.1", "2.0.0")
+	defer repo.Close()
+
+	hc := &environment.HelmChart{
+		ReleaseName:    "adapter",
+		Repository:     repo.URL + "/charts",
+		Chart:          "adapter",
+		Version:        "~1.3",
+		RepositoryAuth: &environment.RepositoryAuth{Username: "helm", Password: "s3cret"},
+	}
+	require.NoError(t, hc.FetchChart())
+	require.Equal(t, "1.3.1", hc.ResolvedVersion)
+	loaded, err := loader.Load(hc.Path)
+	require.NoError(t, err)
+	require.Equal(t, "1.3.1", loaded.Metadata.Version)
+
+	hc.Version = ""
+	require.NoError(t, hc.FetchChart())
+	require.Equal(t, "2.0.0", hc.ResolvedVersion)
+
+	hc.Version = ">3"
+	require.Error(t, hc.FetchChart())
+
+	hc.Version = "1.2.0"
+	t.Setenv("HELMENV_TEST_REPOSITORY_PASSWORD", "s3cret")
+	hc.RepositoryAuth = &environment.RepositoryAuth{Username: "helm", PasswordFrom: "${env:HELMENV_TEST_REPOSITORY_PASSWORD}"}
+	require.NoError(t, hc.FetchChart())
+	require.Equal(t, "1.2.0", hc.ResolvedVersion)
+
+	hc.RepositoryAuth = nil
+	require.Error(t, hc.FetchChart())
+}
+
+func TestRepositoryAuthIsNotWritten(t *testing.T) {
+	t.Parallel()
+	config := &environment.Config{
+		NamespacePrefix: "chainlink",
+		Charts: environment.Charts{
+			"adapter": {
+				Index:          1,
+				Repository:     "oci://registry.example.com/charts",
+				Chart:          "adapter",
+				RepositoryAuth: &environment.RepositoryAuth{Username: "helm", Password: "s3cret", TokenFrom: "${env:REGISTRY_TOKEN}"},
+			},
+		},
+	}
+	path := filepath.Join(t.TempDir(), "env.yaml")
+	require.NoError(t, environment.DumpConfig(config, path))
+	d, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(d), "s3cret")
+	dumped, err := environment.ReadConfigFile(path)
+	require.NoError(t, err)
+	require.Equal(t, &environment.RepositoryAuth{Username: "helm", TokenFrom: "${env:REGISTRY_TOKEN}"}, dumped.Charts["adapter"].RepositoryAuth)
+
+	config.Charts["adapter"].RepositoryAuth = &environment.RepositoryAuth{PasswordFrom: "s3cret"}
+	err = config.Validate()
+	require.ErrorContains(t, err, "chart adapter: repository_auth.password_from must be a reference such as ${env:NAME}")
+	require.NotContains(t, err.Error(), "s3cret")
+}
@@ -0,0 +1,187 @@
+package environment
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	// WarnUnknownValues logs values that aren't in the default values of a chart without a schema
+	WarnUnknownValues = "warn"
+	// RejectUnknownValues fails deploying a chart without a schema with values that aren't in its default values
+	RejectUnknownValues = "reject"
+	// IgnoreUnknownValues doesn't check the values of charts without a schema
+	IgnoreUnknownValues = "ignore"
+
+	globalValuesKey = "global"
+)
+
+// freeFormValuesRegexp matches values that templates range over or render as a whole, any key is valid under them
+var freeFormValuesRegexp = regexp.MustCompile(`(?:range\s+(?:\$\w+\s*(?:,\s*\$\w+\s*)?:=\s*)?|(?:toYaml|toJson|toPrettyJson)\s+)\.Values\.([\w.]+)`)
+
+// UnknownValuesError values given to a chart that aren't in its default values
+type UnknownValuesError struct {
+	Chart string
+	Paths []string
+}
+
+func (e *UnknownValuesError) Error() string {
+	return fmt.Sprintf("chart %s doesn't have values %s", e.Chart, strings.Join(e.Paths, ", "))
+}
+
+// ValidateValues loads the chart and checks the values given to it, against the values.schema.json of the chart if it
+// has one, otherwise for keys that aren't in the default values of the chart
+func (hc *HelmChart) ValidateValues() error {
+	return hc.checkValues(hc.unknownValues(""))
+}
+
+func (hc *HelmChart) checkValues(unknownValuesMode string) error {
+	loadedChart, err := hc.readChart()
+	if err != nil {
+		return err
+	}
+	// references to other charts can only be resolved in a deployed environment
+	values, err := hc.OverrideValues()
+	if hc.env != nil {
+		values, err = hc.chartValues(loadedChart.Name())
+	}
+	if err != nil {
+		return err
+	}
+	return hc.validateValues(loadedChart, values, unknownValuesMode)
+}
+
+func (hc *HelmChart) validateValues(ch *chart.Chart, values map[string]interface{}, mode string) error {
+	if len(ch.Schema) > 0 {
+		coalesced, err := chartutil.CoalesceValues(ch, values)
+		if err != nil {
+			return err
+		}
+		if err := chartutil.ValidateAgainstSchema(ch, coalesced); err != nil {
+			return errors.Wrapf(err, "values of chart %s don't match its schema", hc.ReleaseName)
+		}
+		return nil
+	}
+	// charts without default values can't tell which values they take
+	if mode == IgnoreUnknownValues || (len(ch.Values) == 0 && len(ch.Dependencies()) == 0) {
+		return nil
+	}
+	paths := unknownValues(ch, values, "")
+	if len(paths) == 0 {
+		return nil
+	}
+	sort.Strings(paths)
+	unknownErr := &UnknownValuesError{Chart: hc.ReleaseName, Paths: paths}
+	switch mode {
+	case RejectUnknownValues:
+		return unknownErr
+	case WarnUnknownValues:
+		log.Warn().Str("Chart", hc.ReleaseName).Strs("Values", paths).Msg("Values aren't in the default values of the chart")
+		return nil
+	}
+	return fmt.Errorf("unknown values mode %s of chart %s, must be %s, %s or %s",
+		mode, hc.ReleaseName, WarnUnknownValues, RejectUnknownValues, IgnoreUnknownValues)
+}
+
+// unknownValues returns how the chart treats unknown values, falling back to the mode of the environment, or the
+// given one outside of an environment
+func (hc *HelmChart) unknownValues(configMode string) string {
+	if hc.UnknownValues != "" {
+		return hc.UnknownValues
+	}
+	if hc.env != nil {
+		configMode = hc.env.Config.UnknownValues
+	}
+	if configMode != "" {
+		return configMode
+	}
+	return WarnUnknownValues
+}
+
+// unknownValues returns the paths of values that aren't in the default values of a chart or its subcharts
+func unknownValues(ch *chart.Chart, values map[string]interface{}, prefix string) []string {
+	freeForm := freeFormValues(ch)
+	subcharts := map[string]*chart.Chart{}
+	for _, dependency := range ch.Dependencies() {
+		subcharts[dependency.Name()] = dependency
+	}
+	var paths []string
+	for key, value := range values {
+		if key == globalValuesKey {
+			continue
+		}
+		if subchart, ok := subcharts[key]; ok {
+			if subchartValues, ok := value.(map[string]interface{}); ok {
+				paths = append(paths, unknownValues(subchart, subchartValues, prefix+key+".")...)
+			}
+			continue
+		}
+		paths = append(paths, unknownKeys(ch.Values, key, value, prefix, "", freeForm)...)
+	}
+	return paths
+}
+
+// unknownKeys returns the paths under a key of values that aren't in the defaults, path is the path of the key in
+// the values of the chart and prefix the path of the chart in the values of its parents
+func unknownKeys(defaults map[string]interface{}, key string, value interface{}, prefix string, path string, freeForm map[string]bool) []string {
+	path += key
+	defaultValue, ok := defaults[key]
+	if !ok {
+		return []string{prefix + path}
+	}
+	defaultMap, ok := defaultValue.(map[string]interface{})
+	valueMap, isMap := value.(map[string]interface{})
+	if !ok || !isMap || len(defaultMap) == 0 || freeForm[path] {
+		return nil
+	}
+	var paths []string
+	for k, v := range valueMap {
+		paths = append(paths, unknownKeys(defaultMap, k, v, prefix, path+".", freeForm)...)
+	}
+	return paths
+}
+
+// freeFormValues returns the paths of values the templates of a chart range over or render as YAML
+func freeFormValues(ch *chart.Chart) map[string]bool {
+	paths := map[string]bool{}
+	for _, template := range ch.Templates {
+		for _, match := range freeFormValuesRegexp.FindAllSubmatch(template.Data, -1) {
+			paths[string(match[1])] = true
+		}
+	}
+	return paths
+}
+
+// ValidateValues checks the values of all charts of a preset, see HelmChart.ValidateValues. Charts are read from their
+// source the same way they are when deploying, references to other charts are checked as strings
+func (m *Config) ValidateValues() error {
+	var errs []string
+	for _, name := range sortedKeys(m.Charts) {
+		hc := m.Charts[name]
+		if hc.ReleaseName == "" {
+			hc.ReleaseName = name
+		}
+		if hc.Path == "" {
+			hc.Path = filepath.Join("charts", name)
+		}
+		if err := hc.FetchChart(); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err := hc.checkValues(hc.unknownValues(m.UnknownValues)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid values:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
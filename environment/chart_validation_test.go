@@ -0,0 +1,76 @@
+package environment_test
+
+import (
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// validationChart saves a chart taking an image and free form env and resources, with a schema if one is given
+func validationChart(t *testing.T, schema string) string {
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "node", Version: "0.1.0"},
+		Raw: []*chart.File{{
+			Name: chartutil.ValuesfileName,
+			Data: []byte("image:\n  repository: node\n  tag: 1.0.0\nenv:\n  LOG_LEVEL: info\nresources:\n  cpu: 100m\nreplicas: 1\n"),
+		}},
+		Templates: []*chart.File{{
+			Name: "templates/pod.yaml",
+			Data: []byte("{{- range $key, $value := .Values.env }}{{ $key }}{{ end }}\n{{ toYaml .Values.resources }}\n"),
+		}},
+	}
+	if schema != "" {
+		ch.Schema = []byte(schema)
+	}
+	archive, err := chartutil.Save(ch, t.TempDir())
+	require.NoError(t, err)
+	return archive
+}
+
+func TestValidateValues(t *testing.T) {
+	t.Parallel()
+	hc := &environment.HelmChart{
+		ReleaseName: "node",
+		Path:        validationChart(t, ""),
+		Values: map[string]interface{}{
+			"image":     map[string]interface{}{"tag": "1.1.0"},
+			"env":       map[string]interface{}{"ETH_URL": "ws://geth:8546"},
+			"resources": map[string]interface{}{"memory": "1Gi"},
+		},
+		Set:           []string{"replicas=2"},
+		UnknownValues: environment.RejectUnknownValues,
+	}
+	require.NoError(t, hc.ValidateValues())
+
+	hc.Set = append(hc.Set, "imag.tag=1.2.0", "image.tga=1.2.0")
+	err := hc.ValidateValues()
+	require.EqualError(t, err, "chart node doesn't have values imag, image.tga")
+	require.IsType(t, &environment.UnknownValuesError{}, err)
+
+	hc.UnknownValues = environment.WarnUnknownValues
+	require.NoError(t, hc.ValidateValues())
+	hc.UnknownValues = "fail"
+	require.Error(t, hc.ValidateValues())
+}
+
+func TestValidateValuesSchema(t *testing.T) {
+	t.Parallel()
+	schema := `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "properties": {"replicas": {"type": "integer", "minimum": 1}}
+}`
+	hc := &environment.HelmChart{
+		ReleaseName:   "node",
+		Path:          validationChart(t, schema),
+		Set:           []string{"replicas=3", "unknown=1"},
+		UnknownValues: environment.RejectUnknownValues,
+	}
+	require.NoError(t, hc.ValidateValues(), "the schema decides which values are valid")
+
+	hc.Set = []string{"replicas=0"}
+	require.ErrorContains(t, hc.ValidateValues(), "values of chart node don't match its schema")
+}
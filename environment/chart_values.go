@@ -0,0 +1,206 @@
+package environment
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+// OverrideValues merges the values given to the chart, from lowest to highest precedence: values files in order, inline
+// values, then --set overrides in order. The defaults of the chart itself are coalesced underneath by Helm
+func (hc *HelmChart) OverrideValues() (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, valuesFile := range hc.ValuesFiles {
+		data, err := os.ReadFile(valuesFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read values file of chart %s", hc.ReleaseName)
+		}
+		fileValues := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse values file %s", valuesFile)
+		}
+		values = mergeValues(values, fileValues)
+	}
+	values = mergeValues(values, copyValues(hc.Values))
+	for _, set := range hc.Set {
+		if err := strvals.ParseInto(escapeReferences(set), values); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse --set %s of chart %s", set, hc.ReleaseName)
+		}
+	}
+	return values, nil
+}
+
+// chartValues returns the values of the chart with its references resolved, with the mockserver expectations of the
+// environment added for the mockserver-config chart
+func (hc *HelmChart) chartValues(chartName string) (map[string]interface{}, error) {
+	charts := Charts{}
+	if hc.env != nil {
+		charts = hc.env.Charts
+	}
+	values, secretPaths, err := charts.resolveValues(hc, nil)
+	if err != nil {
+		return nil, err
+	}
+	hc.templatePaths = secretPaths
+	// environment files written before redacted values had to be references hold the placeholder instead
+	if _, err := walkValues(values, "", func(path string, value interface{}) (interface{}, error) {
+		if value == redacted {
+			return nil, fmt.Errorf("value %s of chart %s is a redacted placeholder, give it as a reference such as ${env:NAME}", path, hc.ReleaseName)
+		}
+		return value, nil
+	}); err != nil {
+		return nil, err
+	}
+	values, err = hc.ResolveSecretReferences(values)
+	if err != nil {
+		return nil, err
+	}
+	return hc.withMockserverExpectations(chartName, values), nil
+}
+
+// escapeReferences escapes a --set value holding references to other charts, so its braces and commas aren't parsed
+// as a list, see chart_references.go
+func escapeReferences(set string) string {
+	key, value, ok := strings.Cut(set, "=")
+	if !ok || !strings.Contains(value, referenceDelimiter) {
+		return set
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, ",", `\,`, "{", `\{`).Replace(value)
+	return key + "=" + escaped
+}
+
+// mergeValues merges override into base, nested maps are merged while any other value replaces the base one
+func mergeValues(base, override map[string]interface{}) map[string]interface{} {
+	for key, value := range override {
+		if valueMap, ok := value.(map[string]interface{}); ok {
+			if baseMap, ok := base[key].(map[string]interface{}); ok {
+				base[key] = mergeValues(baseMap, valueMap)
+				continue
+			}
+		}
+		base[key] = value
+	}
+	return base
+}
+
+// copyValues deep copies nested value maps so merging never modifies the values of a chart
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if valueMap, ok := value.(map[string]interface{}); ok {
+			value = copyValues(valueMap)
+		}
+		copied[key] = value
+	}
+	return copied
+}
+
+// ApplyOverrides adds values files given as chart=path and --set overrides given as chart.key.path=value to the
+// charts, after the ones they already have, and returns the names of the charts that changed. A values file the chart
+// already has isn't added again and a --set override replaces the one the chart has for the same key, so overrides
+// don't pile up in the environment file on every upgrade. Values files are made absolute so the environment file can
+// be used from another directory, and the chart of a --set override is what comes before its first dot, so charts
+// whose name has a dot can't be overridden with --set
+func (c Charts) ApplyOverrides(valuesFiles []string, sets []string) ([]string, error) {
+	changed := map[string]bool{}
+	for _, valuesFile := range valuesFiles {
+		chartName, path, ok := strings.Cut(valuesFile, "=")
+		if !ok || chartName == "" || path == "" {
+			return nil, fmt.Errorf("values file %s must be given as chart=path", valuesFile)
+		}
+		chart, err := c.lookup(chartName)
+		if err != nil {
+			return nil, err
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find values file %s", path)
+		}
+		if !containsString(chart.ValuesFiles, absPath) {
+			chart.ValuesFiles = append(chart.ValuesFiles, absPath)
+		}
+		changed[chartName] = true
+	}
+	for _, set := range sets {
+		chartName, override, ok := strings.Cut(set, ".")
+		if !ok || chartName == "" || !strings.Contains(override, "=") {
+			return nil, fmt.Errorf("--set %s must be given as chart.key.path=value", set)
+		}
+		chart, err := c.lookup(chartName)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := strvals.Parse(escapeReferences(override)); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse --set %s", set)
+		}
+		chart.Set = append(removeSets(chart.Set, setKey(override)), override)
+		changed[chartName] = true
+	}
+	var names []string
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// setKey returns the key path a --set override sets
+func setKey(set string) string {
+	key, _, _ := strings.Cut(set, "=")
+	return key
+}
+
+// removeSets returns the --set overrides without the ones for the key, the later one replaces them
+func removeSets(sets []string, key string) []string {
+	var kept []string
+	for _, set := range sets {
+		if setKey(set) != key {
+			kept = append(kept, set)
+		}
+	}
+	return kept
+}
+
+// resolveValuesFiles makes the relative values files of the charts absolute from dir, the directory of the preset
+// listing them, so a preset can be used from any directory
+func (c Charts) resolveValuesFiles(dir string) error {
+	for _, chart := range c {
+		if chart == nil {
+			continue
+		}
+		for i, valuesFile := range chart.ValuesFiles {
+			if filepath.IsAbs(valuesFile) {
+				continue
+			}
+			absPath, err := filepath.Abs(filepath.Join(dir, valuesFile))
+			if err != nil {
+				return errors.Wrapf(err, "failed to find values file %s", valuesFile)
+			}
+			chart.ValuesFiles[i] = absPath
+		}
+	}
+	return nil
+}
+
+// lookup returns a chart by its key, or by its release name once the environment has set it
+func (c Charts) lookup(chartName string) (*HelmChart, error) {
+	if chart, ok := c[chartName]; ok {
+		return chart, nil
+	}
+	return c.Get(chartName)
+}
@@ -0,0 +1,97 @@
+package environment_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverrideValues(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yaml")
+	ci := filepath.Join(dir, "ci.yaml")
+	require.NoError(t, os.WriteFile(base, []byte("chainlink:\n  image:\n    image: chainlink\n    version: 1.0.0\nreplicas: 1\n"), 0644))
+	require.NoError(t, os.WriteFile(ci, []byte("chainlink:\n  image:\n    version: 1.1.0\n"), 0644))
+
+	hc := &environment.HelmChart{
+		ReleaseName: "chainlink",
+		ValuesFiles: []string{base, ci},
+		Values: map[string]interface{}{
+			"replicas": 2,
+			"db":       map[string]interface{}{"stateful": true},
+		},
+		Set: []string{"chainlink.image.version=1.2.0", "db.stateful=false"},
+	}
+	values, err := hc.OverrideValues()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"chainlink": map[string]interface{}{
+			"image": map[string]interface{}{"image": "chainlink", "version": "1.2.0"},
+		},
+		"replicas": 2,
+		"db":       map[string]interface{}{"stateful": false},
+	}, values)
+	require.Equal(t, true, hc.Values["db"].(map[string]interface{})["stateful"], "inline values aren't modified")
+
+	hc.ValuesFiles = []string{filepath.Join(dir, "missing.yaml")}
+	_, err = hc.OverrideValues()
+	require.Error(t, err)
+}
+
+func TestChartsApplyOverrides(t *testing.T) {
+	t.Parallel()
+	charts := environment.Charts{
+		"chainlink": &environment.HelmChart{Set: []string{"replicas=1"}},
+		"geth":      &environment.HelmChart{},
+	}
+	changed, err := charts.ApplyOverrides(
+		[]string{"geth=geth.yaml"},
+		[]string{"chainlink.chainlink.image.version=1.2.0"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"chainlink", "geth"}, changed)
+	require.Equal(t, []string{"replicas=1", "chainlink.image.version=1.2.0"}, charts["chainlink"].Set)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(wd, "geth.yaml")}, charts["geth"].ValuesFiles, "values files are made absolute")
+
+	changed, err = charts.ApplyOverrides(
+		[]string{"geth=geth.yaml", "geth=" + filepath.Join(wd, "geth.yaml")},
+		[]string{"chainlink.chainlink.image.version=1.3.0", "chainlink.replicas=2"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"chainlink", "geth"}, changed, "charts are upgraded with the values files they already have")
+	require.Equal(t, []string{"chainlink.image.version=1.3.0", "replicas=2"}, charts["chainlink"].Set, "overrides of the same key are replaced")
+	require.Equal(t, []string{filepath.Join(wd, "geth.yaml")}, charts["geth"].ValuesFiles, "values files aren't added again")
+
+	_, err = charts.ApplyOverrides([]string{"geth.yaml"}, nil)
+	require.Error(t, err)
+	_, err = charts.ApplyOverrides([]string{"missing=values.yaml"}, nil)
+	require.Error(t, err)
+	_, err = charts.ApplyOverrides(nil, []string{"chainlink.replicas"})
+	require.Error(t, err)
+	_, err = charts.ApplyOverrides(nil, []string{"missing.replicas=1"})
+	require.Error(t, err)
+}
+
+func TestReadConfigFileValuesFiles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	preset := filepath.Join(dir, "presets", "chainlink.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(preset), 0755))
+	ci := filepath.Join(dir, "ci.yaml")
+	require.NoError(t, os.WriteFile(preset, []byte(`charts:
+  chainlink:
+    index: 1
+    values_files:
+      - ../values/base.yaml
+      - `+ci+`
+`), 0644))
+	config, err := environment.ReadConfigFile(preset)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "values", "base.yaml"), ci}, config.Charts["chainlink"].ValuesFiles, "values files are relative to the preset")
+}
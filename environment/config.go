@@ -53,7 +53,22 @@ func (d *MarshalSafeDuration) UnmarshalJSON(b []byte) error {
 	}
 }
 
-func unmarshalYAML(path string, to interface{}) error {
+// UnmarshalYAML unmarshals durations given as nanoseconds or as duration strings, e.g. 100ms
+func (d *MarshalSafeDuration) UnmarshalYAML(value *yaml.Node) error {
+	var nanoseconds int64
+	if err := value.Decode(&nanoseconds); err == nil {
+		*d = MarshalSafeDuration(time.Duration(nanoseconds))
+		return nil
+	}
+	tmp, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return err
+	}
+	*d = MarshalSafeDuration(tmp)
+	return nil
+}
+
+func unmarshalYAML(path string, to *Config) error {
 	ap, err := filepath.Abs(path)
 	if err != nil {
 		return err
@@ -63,22 +78,35 @@ func unmarshalYAML(path string, to interface{}) error {
 	if err != nil {
 		return err
 	}
-	return yaml.Unmarshal(f, to)
+	return decodeConfig(ap, f, to)
 }
 
 // Config represents the full configuration of an environment, it can either be defined
 // programmatically at runtime, or defined in files to be used in a CLI or any other application
 type Config struct {
-	Path               string                           `yaml:"-" json:"-" envconfig:"config_path"`
-	QPS                float32                          `yaml:"qps" json:"qps" envconfig:"qps" default:"50"`
-	Burst              int                              `yaml:"burst" json:"burst" envconfig:"burst" default:"50"`
-	MarshalSafeTimeout MarshalSafeDuration              `yaml:"timeout" json:"timeout" ignored:"true" default:"3m"`
-	Timeout            time.Duration                    `yaml:"-" json:"-" envconfig:"timeout" default:"3m"`
-	Persistent         bool                             `yaml:"persistent" json:"persistent" envconfig:"persistent"`
-	NamespacePrefix    string                           `yaml:"namespace_prefix,omitempty" json:"namespace_prefix,omitempty" envconfig:"namespace_prefix"`
-	Namespace          string                           `yaml:"namespace,omitempty" json:"namespace,omitempty" envconfig:"namespace"`
-	Charts             Charts                           `yaml:"charts,omitempty" json:"charts,omitempty" envconfig:"charts"`
-	Experiments        map[string]*chaos.ExperimentInfo `yaml:"experiments,omitempty" json:"experiments,omitempty" envconfig:"experiments"`
+	Path                   string                           `yaml:"-" json:"-" envconfig:"config_path"`
+	SchemaVersion          int                              `yaml:"schema_version" json:"schema_version" envconfig:"schema_version"`
+	QPS                    float32                          `yaml:"qps" json:"qps" envconfig:"qps" default:"50"`
+	Burst                  int                              `yaml:"burst" json:"burst" envconfig:"burst" default:"50"`
+	MarshalSafeTimeout     MarshalSafeDuration              `yaml:"timeout" json:"timeout" ignored:"true" default:"3m"`
+	Timeout                time.Duration                    `yaml:"-" json:"-" envconfig:"timeout" default:"3m"`
+	Persistent             bool                             `yaml:"persistent" json:"persistent" envconfig:"persistent"`
+	StateStore             string                           `yaml:"state_store,omitempty" json:"state_store,omitempty" envconfig:"state_store"`
+	Offline                bool                             `yaml:"offline,omitempty" json:"offline,omitempty" envconfig:"offline"`
+	VerifyCharts           bool                             `yaml:"verify_charts,omitempty" json:"verify_charts,omitempty" envconfig:"verify_charts"`
+	Keyring                string                           `yaml:"keyring,omitempty" json:"keyring,omitempty" envconfig:"keyring"`
+	UnknownValues          string                           `yaml:"unknown_values,omitempty" json:"unknown_values,omitempty" envconfig:"unknown_values"`
+	RedactedValues         []string                         `yaml:"redacted_values,omitempty" json:"redacted_values,omitempty" envconfig:"redacted_values"`
+	NamespacePrefix        string                           `yaml:"namespace_prefix,omitempty" json:"namespace_prefix,omitempty" envconfig:"namespace_prefix"`
+	Namespace              string                           `yaml:"namespace,omitempty" json:"namespace,omitempty" envconfig:"namespace"`
+	ConnectionStrategy     string                           `yaml:"connection_strategy,omitempty" json:"connection_strategy,omitempty" envconfig:"connection_strategy"`
+	InstanceEnumeration    string                           `yaml:"instance_enumeration,omitempty" json:"instance_enumeration,omitempty" envconfig:"instance_enumeration"`
+	RemoteURLMode          string                           `yaml:"remote_url_mode,omitempty" json:"remote_url_mode,omitempty" envconfig:"remote_url_mode"`
+	NodeAddress            string                           `yaml:"node_address,omitempty" json:"node_address,omitempty" envconfig:"node_address"`
+	Charts                 Charts                           `yaml:"charts,omitempty" json:"charts,omitempty" envconfig:"charts"`
+	Gateway                *GatewayConfig                   `yaml:"gateway,omitempty" json:"gateway,omitempty" envconfig:"gateway"`
+	MockserverExpectations []*MockserverExpectation         `yaml:"mockserver_expectations,omitempty" json:"mockserver_expectations,omitempty" envconfig:"mockserver_expectations"`
+	Experiments            map[string]*chaos.ExperimentInfo `yaml:"experiments,omitempty" json:"experiments,omitempty" envconfig:"experiments"`
 }
 
 // ToJSON marshals the config to JSON
@@ -89,7 +117,7 @@ func (m *Config) ToJSON() ([]byte, error) {
 // Decode marshals the config from a provided yaml file
 func (m *Config) Decode(path string) error {
 	// Marshal YAML first, then "envconfig" tags of that struct got marshalled
-	if err := unmarshalYAML(path, &m); err != nil {
+	if err := unmarshalYAML(path, m); err != nil {
 		return err
 	}
 	return envconfig.Process("", m)
@@ -122,6 +150,15 @@ func (c Charts) Connections(chart string) *ChartConnections {
 	}
 }
 
+// Services is a helper method for simply accessing service connections of a chart
+func (c Charts) Services(chart string) ServiceConnections {
+	if chart, ok := c[chart]; !ok {
+		return ServiceConnections{}
+	} else {
+		return chart.ServiceConnections
+	}
+}
+
 // ExecuteInPod is similar to kubectl exec
 func (c Charts) ExecuteInPod(chartName string, podNameSubstring string, podIndex int, containerName string, command []string) error {
 	chart, ok := c[chartName]
@@ -187,34 +224,34 @@ func (c Charts) OrderedKeys() [][]string {
 	return keys
 }
 
-// DumpConfig dumps config to a yaml file
+// DumpConfig dumps config to a yaml file, replacing the file at once and keeping its previous version as a backup
 func DumpConfig(cfg *Config, path string) error {
-	f, err := os.Create(path)
-	if err != nil {
+	cfg.SchemaVersion = CurrentSchemaVersion
+	if err := checkRedactedValues(cfg); err != nil {
 		return err
 	}
 	d, err := yaml.Marshal(cfg)
 	if err != nil {
 		return err
 	}
-	if _, err := f.Write(d); err != nil {
+	if err := writeConfigFile(path, d); err != nil {
 		return err
 	}
 	log.Info().Str("Path", path).Str("Format", "yaml").Msg("Config file written")
 	return nil
 }
 
-// DumpConfigJson dumps config to a json file
+// DumpConfigJson dumps config to a json file, replacing the file at once and keeping its previous version as a backup
 func DumpConfigJson(cfg *Config, path string) error {
-	f, err := os.Create(path)
-	if err != nil {
+	cfg.SchemaVersion = CurrentSchemaVersion
+	if err := checkRedactedValues(cfg); err != nil {
 		return err
 	}
 	d, err := json.Marshal(cfg)
 	if err != nil {
 		return err
 	}
-	if _, err := f.Write(d); err != nil {
+	if err := writeConfigFile(path, d); err != nil {
 		return err
 	}
 	log.Info().Str("Path", path).Str("Format", "json").Msg("Config file written")
@@ -229,48 +266,65 @@ func DeployOrLoadEnvironment(config *Config) (*Environment, error) {
 	if len(envFile) > 0 {
 		return DeployOrLoadEnvironmentFromConfigFile(envFile)
 	}
+	if namespace := os.Getenv("ENVIRONMENT_NAMESPACE"); len(namespace) > 0 {
+		return LoadEnvironmentFromNamespace(namespace)
+	}
 	return deployOrLoadEnvironment(config)
 }
 
 // DeployOrLoadEnvironmentFromConfigFile returns an environment based on a preset file, mostly for use as a presets CLI
 func DeployOrLoadEnvironmentFromConfigFile(configFilePath string) (*Environment, error) {
+	config, err := ReadConfigFile(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return deployOrLoadEnvironment(config)
+}
+
+// DeployOrLoadEnvironmentWithOverrides returns an environment based on a preset file, with values files and --set
+// overrides added to its charts, see Charts.ApplyOverrides
+func DeployOrLoadEnvironmentWithOverrides(configFilePath string, valuesFiles []string, sets []string) (*Environment, error) {
+	config, err := ReadConfigFile(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := config.Charts.ApplyOverrides(valuesFiles, sets); err != nil {
+		return nil, err
+	}
+	return deployOrLoadEnvironment(config)
+}
+
+// ReadConfigFile reads a yaml or json preset or environment file
+func ReadConfigFile(configFilePath string) (*Config, error) {
 	contents, err := os.ReadFile(configFilePath)
 	if err != nil {
 		return nil, err
 	}
 	config := &Config{}
-	configFileExt := filepath.Ext(configFilePath)
-	log.Info().Str("Config File", configFilePath).Str("Extension", configFileExt).Msg("Reading from config file")
-	switch configFileExt {
-	case ".yaml", ".yml":
-		if err := yaml.Unmarshal(contents, &config); err != nil {
-			return nil, err
-		}
-	case ".json":
-		if err := json.Unmarshal(contents, &config); err != nil {
-			return nil, err
-		}
-	default:
-		err = fmt.Errorf("Invalid file extension '%s' for config file, must be yaml or json", configFileExt)
-		log.Error().Str("Config File Path", configFilePath).
-			Str("Extension", configFileExt).
-			Err(err).
-			Msg("Error reading Config File")
+	log.Info().Str("Config File", configFilePath).Str("Extension", filepath.Ext(configFilePath)).Msg("Reading from config file")
+	if err := decodeConfig(configFilePath, contents, config); err != nil {
+		log.Error().Str("Config File Path", configFilePath).Err(err).Msg("Error reading Config File")
+		return nil, err
+	}
+	// values files of a preset are relative to it, not to the directory it's used from
+	if err := config.Charts.resolveValuesFiles(filepath.Dir(configFilePath)); err != nil {
 		return nil, err
 	}
-
 	config.Path = configFilePath
 	config.Timeout = config.MarshalSafeTimeout.AsTimeDuration()
 	// Always set to true when loading from file as the environment state would be lost on deployment since if false
 	// config isn't written to disk
 	config.Persistent = true
-	return deployOrLoadEnvironment(config)
+	return config, nil
 }
 
 func deployOrLoadEnvironment(config *Config) (*Environment, error) {
 	if err := envconfig.Process("", config); err != nil {
 		return nil, err
 	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	if len(config.Namespace) > 0 {
 		return LoadEnvironment(config)
 	}
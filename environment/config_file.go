@@ -0,0 +1,93 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	configBackupExtension = ".bak"
+	configLockExtension   = ".lock"
+)
+
+// ConfigFileLock is an advisory lock of a config file held across a read-modify-write cycle, the lock is taken on a
+// file next to the config file since writes replace the config file
+type ConfigFileLock struct {
+	path string
+	file *os.File
+}
+
+// LockConfigFile waits until no other process holds the lock of the config file and takes it
+func LockConfigFile(path string) (*ConfigFileLock, error) {
+	lockPath := path + configLockExtension
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open lock file %s", lockPath)
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		return nil, errors.Wrapf(err, "failed to lock config file %s", path)
+	}
+	log.Debug().Str("Path", path).Msg("Config file locked")
+	return &ConfigFileLock{path: path, file: f}, nil
+}
+
+// Unlock releases the lock of the config file
+func (l *ConfigFileLock) Unlock() error {
+	if err := unlockFile(l.file); err != nil {
+		_ = l.file.Close()
+		return errors.Wrapf(err, "failed to unlock config file %s", l.path)
+	}
+	log.Debug().Str("Path", l.path).Msg("Config file unlocked")
+	return l.file.Close()
+}
+
+// UpdateConfigFile reads a config file, updates it and writes it back while holding its lock, so changes of other
+// processes made in the meantime aren't lost
+func UpdateConfigFile(path string, update func(config *Config) error) error {
+	lock, err := LockConfigFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			log.Error().Err(err).Msg("Error while unlocking config file")
+		}
+	}()
+	config, err := ReadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if err := update(config); err != nil {
+		return err
+	}
+	if filepath.Ext(path) == ".json" {
+		return DumpConfigJson(config, path)
+	}
+	return DumpConfig(config, path)
+}
+
+// writeConfigFile replaces a config file with a temporary file so it's never partially written, the previous version
+// is kept next to it with a .bak extension. An existing file keeps its mode, a new one is only readable by the user as
+// it holds the state of the environment
+func writeConfigFile(path string, data []byte) error {
+	perm := os.FileMode(0600)
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		perm = info.Mode().Perm()
+		previous, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path+configBackupExtension, previous, perm); err != nil {
+			return errors.Wrapf(err, "failed to back up config file %s", path)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	return writeFileAtomic(path, data, perm)
+}
@@ -0,0 +1,76 @@
+package environment_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpConfigKeepsBackup(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "env.yaml")
+	config := &environment.Config{Namespace: "chainlink-abcde"}
+	require.NoError(t, environment.DumpConfig(config, path))
+	_, err := os.Stat(path + ".bak")
+	require.True(t, os.IsNotExist(err), "a new file has no previous version")
+	previous, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	config.Namespace = "chainlink-fghij"
+	require.NoError(t, environment.DumpConfig(config, path))
+	backup, err := os.ReadFile(path + ".bak")
+	require.NoError(t, err)
+	require.Equal(t, previous, backup)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "temporary files are renamed or removed")
+	for _, file := range []string{path, path + ".bak"} {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm(), "the state of the environment is only readable by the user")
+	}
+
+	require.NoError(t, os.Chmod(path, 0640))
+	require.NoError(t, environment.DumpConfig(config, path))
+	for _, file := range []string{path, path + ".bak"} {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0640), info.Mode().Perm(), "an existing file keeps its mode")
+	}
+}
+
+func TestUpdateConfigFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "env.json")
+	require.NoError(t, environment.DumpConfigJson(&environment.Config{Namespace: "chainlink-abcde"}, path))
+
+	lock, err := environment.LockConfigFile(path)
+	require.NoError(t, err)
+	info, err := os.Stat(path + ".lock")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	updated := make(chan error)
+	go func() {
+		updated <- environment.UpdateConfigFile(path, func(config *environment.Config) error {
+			config.NamespacePrefix = "chainlink"
+			return nil
+		})
+	}()
+	select {
+	case <-updated:
+		t.Fatal("config file was updated while locked")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, lock.Unlock())
+	require.NoError(t, <-updated)
+
+	config, err := environment.ReadConfigFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "chainlink-abcde", config.Namespace)
+	require.Equal(t, "chainlink", config.NamespacePrefix)
+}
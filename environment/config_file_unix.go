@@ -0,0 +1,17 @@
+//go:build !windows
+
+package environment
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock of the file, waiting for other processes to release it
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,170 @@
+package environment
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/json"
+)
+
+// CurrentSchemaVersion version of the config format written by this version, files without a version are version 0
+const CurrentSchemaVersion = 1
+
+// ConfigMigration upgrades a decoded config file from the previous schema version to Version
+type ConfigMigration struct {
+	Version     int
+	Description string
+	Migrate     func(config map[string]interface{}) error
+}
+
+// configMigrations upgrade config files one version at a time, in order
+var configMigrations = []ConfigMigration{
+	{
+		Version:     1,
+		Description: "record app, instance and container of chart connections stored before they were fields",
+		Migrate:     migrateConnectionIdentities,
+	},
+}
+
+// MigrateConfig upgrades a decoded config file to the current schema version and returns the version it had, files
+// written by a newer version can't be loaded
+func MigrateConfig(config map[string]interface{}) (int, error) {
+	version, err := schemaVersion(config)
+	if err != nil {
+		return 0, err
+	}
+	if version > CurrentSchemaVersion {
+		return version, fmt.Errorf("config has schema version %d, this version supports up to %d", version, CurrentSchemaVersion)
+	}
+	for _, migration := range configMigrations {
+		if migration.Version <= version {
+			continue
+		}
+		if err := migration.Migrate(config); err != nil {
+			return version, fmt.Errorf("failed to migrate config to schema version %d: %v", migration.Version, err)
+		}
+		log.Debug().Int("Version", migration.Version).Str("Migration", migration.Description).Msg("Config migrated")
+	}
+	config["schema_version"] = CurrentSchemaVersion
+	return version, nil
+}
+
+// MigrateConfigFile rewrites a config file in the current schema version, keeping the original next to it with a
+// .bak extension. It returns the version the file had, files already in the current version are left untouched
+func MigrateConfigFile(path string) (int, error) {
+	lock, err := LockConfigFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			log.Error().Err(err).Msg("Error while unlocking config file")
+		}
+	}()
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	raw := map[string]interface{}{}
+	if err := unmarshalConfig(path, contents, &raw); err != nil {
+		return 0, err
+	}
+	version, err := schemaVersion(raw)
+	if err != nil || version == CurrentSchemaVersion {
+		return version, err
+	}
+	config, err := ReadConfigFile(path)
+	if err != nil {
+		return version, err
+	}
+	if filepath.Ext(path) == ".json" {
+		return version, DumpConfigJson(config, path)
+	}
+	return version, DumpConfig(config, path)
+}
+
+// decodeConfig decodes a yaml or json config file, migrating it to the current schema version first
+func decodeConfig(path string, contents []byte, config *Config) error {
+	raw := map[string]interface{}{}
+	if err := unmarshalConfig(path, contents, &raw); err != nil {
+		return err
+	}
+	version, err := MigrateConfig(raw)
+	if err != nil {
+		return fmt.Errorf("failed to load config %s: %v", path, err)
+	}
+	if version != CurrentSchemaVersion {
+		log.Debug().Str("Path", path).Int("From", version).Int("To", CurrentSchemaVersion).Msg("Migrated config schema")
+	}
+	var migrated []byte
+	if filepath.Ext(path) == ".json" {
+		migrated, err = json.Marshal(raw)
+	} else {
+		migrated, err = yaml.Marshal(raw)
+	}
+	if err != nil {
+		return err
+	}
+	return unmarshalConfig(path, migrated, config)
+}
+
+func unmarshalConfig(path string, contents []byte, to interface{}) error {
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(contents, to)
+	case ".json":
+		return json.Unmarshal(contents, to)
+	default:
+		return fmt.Errorf("Invalid file extension '%s' for config file, must be yaml or json", ext)
+	}
+}
+
+func schemaVersion(config map[string]interface{}) (int, error) {
+	switch version := config["schema_version"].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return version, nil
+	case int64:
+		return int(version), nil
+	case float64:
+		if version != math.Trunc(version) {
+			return 0, fmt.Errorf("schema_version %v isn't an integer", version)
+		}
+		return int(version), nil
+	default:
+		return 0, fmt.Errorf("schema_version %v isn't a number", version)
+	}
+}
+
+// migrateConnectionIdentities fills app, instance and container of chart connections from their keys
+func migrateConnectionIdentities(config map[string]interface{}) error {
+	charts, _ := config["charts"].(map[string]interface{})
+	for _, chart := range charts {
+		chartMap, _ := chart.(map[string]interface{})
+		connections, _ := chartMap["chart_connections"].(map[string]interface{})
+		for key, connection := range connections {
+			connectionMap, ok := connection.(map[string]interface{})
+			if !ok || connectionMap["app"] != nil {
+				continue
+			}
+			app, instance, container, ok := parseMapKey(key)
+			if !ok {
+				continue
+			}
+			instanceNumber, err := strconv.Atoi(instance)
+			if err != nil {
+				continue
+			}
+			connectionMap["app"] = app
+			connectionMap["instance"] = instanceNumber
+			connectionMap["container"] = container
+		}
+	}
+	return nil
+}
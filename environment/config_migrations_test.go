@@ -0,0 +1,68 @@
+package environment_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+)
+
+const legacyEnvFile = `namespace: chainlink-abcde
+persistent: true
+charts:
+  geth:
+    index: 1
+    release_name: geth
+    chart_connections:
+      geth_0_geth-network:
+        pod_name: geth-0
+        pod_ip: 10.0.0.2
+        remote_ports:
+          ws-rpc: 8546
+`
+
+func TestMigrateConfigFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "env.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(legacyEnvFile), 0644))
+
+	config, err := environment.ReadConfigFile(path)
+	require.NoError(t, err)
+	connection := config.Charts["geth"].ChartConnections["geth_0_geth-network"]
+	require.Equal(t, "geth", connection.App)
+	require.Equal(t, "geth-network", connection.Container)
+
+	version, err := environment.MigrateConfigFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+	backup, err := os.ReadFile(path + ".bak")
+	require.NoError(t, err)
+	require.Equal(t, legacyEnvFile, string(backup))
+	migrated, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(migrated), "schema_version: 1")
+	require.Contains(t, string(migrated), "app: geth")
+
+	version, err = environment.MigrateConfigFile(path)
+	require.NoError(t, err)
+	require.Equal(t, environment.CurrentSchemaVersion, version)
+}
+
+func TestMigrateConfigNewerVersion(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "env.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"schema_version": 99, "namespace": "chainlink-abcde"}`), 0644))
+	_, err := environment.ReadConfigFile(path)
+	require.ErrorContains(t, err, "config has schema version 99, this version supports up to 1")
+	require.NoError(t, os.WriteFile(path, []byte(`{"schema_version": 0.5, "namespace": "chainlink-abcde"}`), 0644))
+	_, err = environment.ReadConfigFile(path)
+	require.ErrorContains(t, err, "schema_version 0.5 isn't an integer")
+
+	config := map[string]interface{}{"charts": map[string]interface{}{}}
+	version, err := environment.MigrateConfig(config)
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+	require.Equal(t, environment.CurrentSchemaVersion, config["schema_version"])
+}
@@ -1,6 +1,7 @@
 package environment
 
 import (
+	"fmt"
 	"path/filepath"
 	"reflect"
 
@@ -19,8 +20,22 @@ func NewChainlinkChart(index int, values map[string]interface{}) *HelmChart {
 	return &HelmChart{Values: values, Index: index}
 }
 
-// NewChainlinkCCIPReorgConfig returns a Chainlink environment for the purpose of CCIP testing
+// NewChainlinkCCIPReorgConfig returns a Chainlink environment for the purpose of CCIP testing, it panics with fewer
+// than 2 network IDs, use NewChainlinkCCIPReorgConfigChecked to get an error instead
 func NewChainlinkCCIPReorgConfig(chainlinkValues map[string]interface{}, networkIDs []int) *Config {
+	config, err := NewChainlinkCCIPReorgConfigChecked(chainlinkValues, networkIDs)
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
+// NewChainlinkCCIPReorgConfigChecked returns a Chainlink environment for the purpose of CCIP testing, with a chain for
+// each of the first 2 network IDs
+func NewChainlinkCCIPReorgConfigChecked(chainlinkValues map[string]interface{}, networkIDs []int) (*Config, error) {
+	if len(networkIDs) < 2 {
+		return nil, fmt.Errorf("CCIP reorg config needs 2 network IDs, got %d", len(networkIDs))
+	}
 	return &Config{
 		NamespacePrefix: "chainlink-ccip",
 		Charts: Charts{
@@ -50,7 +65,7 @@ func NewChainlinkCCIPReorgConfig(chainlinkValues map[string]interface{}, network
 			},
 			"chainlink": NewChainlinkChart(3, ChainlinkReplicas(5, chainlinkValues)),
 		},
-	}
+	}, nil
 }
 
 // NewTerraChainlinkConfig returns a Chainlink environment designed for testing with a Terra relay
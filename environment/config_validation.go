@@ -0,0 +1,206 @@
+package environment
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+// ValidationError all the problems found in a config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config:\n  %s", strings.Join(e.Problems, "\n  "))
+}
+
+// Validate checks the whole config before anything is deployed and reports every problem at once
+func (m *Config) Validate() error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if m.Namespace == "" && m.NamespacePrefix == "" {
+		addProblem("namespace_prefix is empty, a new environment needs one to create its namespace")
+	}
+	if m.Timeout < 0 || m.MarshalSafeTimeout < 0 {
+		addProblem("timeout can't be negative")
+	}
+	if m.QPS < 0 || m.Burst < 0 {
+		addProblem("qps and burst can't be negative")
+	}
+	switch m.StateStore {
+	case "", FileStateStore, SecretStateStore, ConfigMapStateStore:
+	default:
+		addProblem("state store %s doesn't exist, must be %s, %s or %s", m.StateStore, FileStateStore, SecretStateStore, ConfigMapStateStore)
+	}
+	problems = append(problems, validateModes("config", m.ConnectionStrategy, m.InstanceEnumeration, m.RemoteURLMode, m.UnknownValues)...)
+	releases := map[string]string{}
+	for _, name := range sortedKeys(m.Charts) {
+		hc := m.Charts[name]
+		if hc == nil {
+			addProblem("chart %s is empty", name)
+			continue
+		}
+		release := hc.ReleaseName
+		if release == "" {
+			release = name
+		}
+		if other, ok := releases[release]; ok {
+			addProblem("charts %s and %s have the same release name %s", other, name, release)
+		}
+		releases[release] = name
+		problems = append(problems, hc.validate(name, m.Namespace == "")...)
+		for _, problem := range hc.literalRedactedValues(m.RedactedValues) {
+			addProblem("chart %s: %s", name, problem)
+		}
+	}
+	// references are resolved from the values files when charts are deployed
+	if len(problems) == 0 && m.Namespace == "" {
+		if err := m.Charts.CheckReferences(); err != nil {
+			addProblem(err.Error())
+		}
+	}
+	for i, expectation := range m.MockserverExpectations {
+		switch {
+		case expectation == nil:
+			addProblem("mockserver expectation %d is empty", i)
+		case expectation.Path == "":
+			addProblem("mockserver expectation %d has no path", i)
+		case expectation.Delay < 0 || expectation.Times < 0:
+			addProblem("mockserver expectation %s can't have a negative delay or times", expectation.Path)
+		}
+	}
+	for _, name := range sortedKeys(m.Experiments) {
+		experiment := m.Experiments[name]
+		// stopped experiments are kept as empty entries
+		if experiment == nil {
+			continue
+		}
+		if experiment.Name != name {
+			addProblem("experiment %s refers to experiment %s", name, experiment.Name)
+		}
+		if experiment.Resource == "" {
+			addProblem("experiment %s has no resource", name)
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validate returns the problems of a chart of the config, the chart source and values files are only checked if the
+// chart is about to be deployed, a deployed environment is loaded without them
+func (hc *HelmChart) validate(name string, deploying bool) []string {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("chart %s: %s", name, fmt.Sprintf(format, args...)))
+	}
+	if hc.Index <= 0 {
+		addProblem("index is %d, indexes start at 1", hc.Index)
+	}
+	if deploying {
+		problems = append(problems, hc.validateSource(name)...)
+	}
+	for _, set := range hc.Set {
+		if _, err := strvals.Parse(escapeReferences(set)); err != nil {
+			addProblem("invalid --set %s: %v", set, err)
+		}
+	}
+	for _, problem := range checkSecretReferences(hc.Values) {
+		addProblem(problem)
+	}
+	if auth := hc.RepositoryAuth; auth != nil {
+		references := map[string]interface{}{"password_from": auth.PasswordFrom, "token_from": auth.TokenFrom}
+		for _, field := range []string{"password_from", "token_from"} {
+			if reference := references[field].(string); reference != "" && !secretReferenceRegexp.MatchString(reference) {
+				// the value isn't repeated, it's likely the secret itself
+				addProblem("repository_auth.%s must be a reference such as ${env:NAME}", field)
+			}
+		}
+		for _, problem := range checkSecretReferences(map[string]interface{}{"repository_auth": references}) {
+			addProblem(problem)
+		}
+	}
+	return append(problems, validateModes("chart "+name, hc.ConnectionStrategy, hc.InstanceEnumeration, hc.RemoteURLMode, hc.UnknownValues)...)
+}
+
+// validateSource returns the problems of the source and values files of a chart
+func (hc *HelmChart) validateSource(name string) []string {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("chart %s: %s", name, fmt.Sprintf(format, args...)))
+	}
+	sources := 0
+	for _, source := range []string{hc.URL, hc.Repository, hc.GitRepository} {
+		if source != "" {
+			sources++
+		}
+	}
+	switch {
+	case sources > 1:
+		addProblem("only one of url, repository and git_repository can be set")
+	case hc.URL != "":
+		if u, err := url.Parse(hc.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			addProblem("url %s isn't an http or https URL", hc.URL)
+		}
+	case hc.Repository != "":
+		if u, err := url.Parse(hc.Repository); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != OCIScheme) {
+			addProblem("repository %s isn't an http, https or oci URL", hc.Repository)
+		}
+		if hc.Chart == "" {
+			addProblem("chart of repository %s isn't set", hc.Repository)
+		}
+	case hc.GitRepository == "":
+		if !chartPathExists(hc.Path, name) {
+			addProblem("path %s is neither a chart on the host nor an embedded chart", hc.Path)
+		}
+	}
+	for _, valuesFile := range hc.ValuesFiles {
+		if _, err := os.Stat(valuesFile); err != nil {
+			addProblem("values file %s doesn't exist", valuesFile)
+		}
+	}
+	return problems
+}
+
+// chartPathExists returns true if the path of a chart is on the host or embedded, charts without a path are embedded
+func chartPathExists(chartPath string, name string) bool {
+	if chartPath == "" {
+		chartPath = filepath.Join("charts", name)
+	}
+	if _, err := os.Stat(chartPath); err == nil {
+		return true
+	}
+	_, err := fs.Stat(ChartsFS, filepath.ToSlash(chartPath))
+	return err == nil
+}
+
+// validateModes returns the problems of the modes of a chart or the config, empty modes fall back to the defaults
+func validateModes(owner string, connectionStrategy, instanceEnumeration, remoteURLMode, unknownValues string) []string {
+	var problems []string
+	if _, err := GetConnectionStrategy(connectionStrategy); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", owner, err))
+	}
+	if _, ok := instanceOrders[instanceEnumeration]; instanceEnumeration != "" && !ok {
+		problems = append(problems, fmt.Sprintf("%s: instance enumeration %s doesn't exist", owner, instanceEnumeration))
+	}
+	switch remoteURLMode {
+	case "", PodIPRemoteURLs, ServiceDNSRemoteURLs, PodDNSRemoteURLs:
+	default:
+		problems = append(problems, fmt.Sprintf("%s: remote URL mode %s doesn't exist", owner, remoteURLMode))
+	}
+	switch unknownValues {
+	case "", WarnUnknownValues, RejectUnknownValues, IgnoreUnknownValues:
+	default:
+		problems = append(problems, fmt.Sprintf("%s: unknown values mode %s doesn't exist", owner, unknownValues))
+	}
+	return problems
+}
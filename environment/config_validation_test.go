@@ -0,0 +1,86 @@
+package environment_test
+
+import (
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/chaos"
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+	config := &environment.Config{
+		NamespacePrefix: "chainlink",
+		Charts: environment.Charts{
+			"geth":      {Index: 1},
+			"chainlink": {Index: 2, Values: map[string]interface{}{"eth_url": `{{ connection "geth" "geth" 0 "ws-rpc" "ws" }}`}},
+		},
+	}
+	require.NoError(t, config.Validate())
+
+	config = &environment.Config{
+		MarshalSafeTimeout: environment.MarshalSafeDuration(-1),
+		RemoteURLMode:      "pod-name",
+		Charts: environment.Charts{
+			"geth":    {ReleaseName: "node"},
+			"adapter": {Index: 1, ReleaseName: "node", URL: "ftp://example.com/adapter.tgz", ConnectionStrategy: "vpn"},
+			"missing": {Index: 2, Path: "charts/missing", Set: []string{"replicas"}},
+			"repo":    {Index: 2, Repository: "https://charts.example.com", URL: "https://example.com/repo.tgz"},
+		},
+		MockserverExpectations: []*environment.MockserverExpectation{{Method: "GET"}},
+		Experiments: map[string]*chaos.ExperimentInfo{
+			"pod-failure-1": {Name: "pod-failure-2", Resource: "podchaos"},
+			"pod-failure-3": nil,
+		},
+	}
+	err := config.Validate()
+	require.IsType(t, &environment.ValidationError{}, err)
+	require.Equal(t, []string{
+		"namespace_prefix is empty, a new environment needs one to create its namespace",
+		"timeout can't be negative",
+		"config: remote URL mode pod-name doesn't exist",
+		"chart adapter: url ftp://example.com/adapter.tgz isn't an http or https URL",
+		"chart adapter: connection strategy vpn doesn't exist",
+		"charts adapter and geth have the same release name node",
+		"chart geth: index is 0, indexes start at 1",
+		"chart missing: path charts/missing is neither a chart on the host nor an embedded chart",
+		"chart missing: invalid --set replicas: key \"replicas\" has no value",
+		"chart repo: only one of url, repository and git_repository can be set",
+		"mockserver expectation 0 has no path",
+		"experiment pod-failure-1 refers to experiment pod-failure-2",
+	}, err.(*environment.ValidationError).Problems)
+
+	config = &environment.Config{
+		Namespace: "chainlink-1234",
+		Charts: environment.Charts{
+			"chainlink": {Index: 1, Path: "charts/missing", ValuesFiles: []string{"missing.yaml"}},
+		},
+	}
+	require.NoError(t, config.Validate(), "the source and values files of a deployed environment aren't needed")
+}
+
+func TestConfigValidateReferences(t *testing.T) {
+	t.Parallel()
+	config := &environment.Config{
+		NamespacePrefix: "chainlink",
+		Charts: environment.Charts{
+			"geth":      {Index: 2, Values: map[string]interface{}{"peers": `{{ value "chainlink" "replicas" }}`}},
+			"chainlink": {Index: 2, Values: map[string]interface{}{"eth_url": `{{ connection "geth" "geth" 0 "ws-rpc" "ws" }}`}},
+		},
+	}
+	require.EqualError(t, config.Validate(), "invalid config:\n  charts reference each other in a cycle: chainlink -> geth -> chainlink")
+}
+
+func TestNewChainlinkCCIPReorgConfig(t *testing.T) {
+	t.Parallel()
+	_, err := environment.NewChainlinkCCIPReorgConfigChecked(map[string]interface{}{}, []int{1337})
+	require.EqualError(t, err, "CCIP reorg config needs 2 network IDs, got 1")
+	require.PanicsWithError(t, "CCIP reorg config needs 2 network IDs, got 1", func() {
+		environment.NewChainlinkCCIPReorgConfig(map[string]interface{}{}, []int{1337})
+	})
+	config, err := environment.NewChainlinkCCIPReorgConfigChecked(map[string]interface{}{}, []int{1337, 2337, 3337})
+	require.NoError(t, err)
+	require.Equal(t, 2337, config.Charts["geth-reorg-2"].Values["geth"].(map[string]interface{})["genesis"].(map[string]interface{})["networkId"])
+	require.Equal(t, config, environment.NewChainlinkCCIPReorgConfig(map[string]interface{}{}, []int{1337, 2337}))
+}
@@ -0,0 +1,307 @@
+package environment
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DaemonStartTimeout how long to wait for a detached connect daemon to start serving
+	DaemonStartTimeout = 2 * time.Minute
+	// DaemonStopTimeout how long to wait for a connect daemon to exit after asking it to stop
+	DaemonStopTimeout = 30 * time.Second
+
+	daemonStatusCommand = "status"
+	daemonStopCommand   = "stop"
+)
+
+// DaemonFiles paths of the files a connect daemon of an environment file uses
+type DaemonFiles struct {
+	PidFile string
+	Socket  string
+	LogFile string
+}
+
+// ForwardStatus status of a single connected port
+type ForwardStatus struct {
+	Chart        string `json:"chart"`
+	Connection   string `json:"connection"`
+	Port         string `json:"port"`
+	LocalAddress string `json:"local_address"`
+	Healthy      bool   `json:"healthy"`
+}
+
+// DaemonStatus status of a running connect daemon
+type DaemonStatus struct {
+	Pid             int             `json:"pid"`
+	Namespace       string          `json:"namespace"`
+	EnvironmentFile string          `json:"environment_file"`
+	StartedAt       time.Time       `json:"started_at"`
+	GatewayURL      string          `json:"gateway_url,omitempty"`
+	Forwards        []ForwardStatus `json:"forwards"`
+}
+
+type daemonRequest struct {
+	Command string `json:"command"`
+}
+
+type daemonResponse struct {
+	Status *DaemonStatus `json:"status,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// GetDaemonFiles returns the daemon files of an environment file, they are kept in ~/.helmenv/run
+// as socket paths are limited in length
+func GetDaemonFiles(environmentPath string) (*DaemonFiles, error) {
+	ap, err := filepath.Abs(environmentPath)
+	if err != nil {
+		return nil, err
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	runDir := filepath.Join(homeDir, ".helmenv", "run")
+	if err := mkdirIfNotExists(runDir); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(ap))
+	name := hex.EncodeToString(sum[:])[:16]
+	return &DaemonFiles{
+		PidFile: filepath.Join(runDir, name+".pid"),
+		Socket:  filepath.Join(runDir, name+".sock"),
+		LogFile: filepath.Join(runDir, name+".log"),
+	}, nil
+}
+
+// StartConnectDaemon runs `envcli connect` for the environment file in a detached background process and waits
+// until it serves its control socket, args are the command line arguments of the daemon process
+func StartConnectDaemon(environmentPath string, args []string) (*DaemonStatus, error) {
+	files, err := GetDaemonFiles(environmentPath)
+	if err != nil {
+		return nil, err
+	}
+	if status, err := QueryConnectDaemon(environmentPath); err == nil {
+		return nil, fmt.Errorf("environment is already connected by daemon with pid %d", status.Pid)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	logFile, err := os.OpenFile(files.LogFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "failed to start connect daemon")
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	deadline := time.After(DaemonStartTimeout)
+	for {
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("connect daemon exited before connecting: %v, see logs in %s", err, files.LogFile)
+		case <-deadline:
+			return nil, fmt.Errorf("timed out waiting for connect daemon, see logs in %s", files.LogFile)
+		case <-time.After(500 * time.Millisecond):
+			if status, err := QueryConnectDaemon(environmentPath); err == nil {
+				return status, nil
+			}
+		}
+	}
+}
+
+// ServeConnectDaemon serves the control socket of a connected environment and blocks until it's asked to stop,
+// or the interrupt channel receives
+func ServeConnectDaemon(e *Environment, interrupt <-chan os.Signal) error {
+	files, err := GetDaemonFiles(e.Path)
+	if err != nil {
+		return err
+	}
+	_ = os.Remove(files.Socket)
+	listener, err := net.Listen("unix", files.Socket)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = listener.Close()
+		_ = os.Remove(files.Socket)
+		_ = os.Remove(files.PidFile)
+	}()
+	if err := os.WriteFile(files.PidFile, []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+		return err
+	}
+	startedAt := time.Now()
+	stop := make(chan struct{})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if stopRequested := e.handleDaemonRequest(conn, startedAt); stopRequested {
+				close(stop)
+				return
+			}
+		}
+	}()
+	log.Info().Str("Socket", files.Socket).Int("Pid", os.Getpid()).Msg("Connect daemon serving")
+	select {
+	case <-stop:
+	case <-interrupt:
+	}
+	log.Info().Str("Namespace", e.Namespace).Msg("Connect daemon stopping")
+	return nil
+}
+
+// handleDaemonRequest answers a single control request, returns true if the daemon was asked to stop
+func (k *Environment) handleDaemonRequest(conn net.Conn, startedAt time.Time) bool {
+	defer conn.Close()
+	var req daemonRequest
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		_ = json.NewEncoder(conn).Encode(daemonResponse{Error: err.Error()})
+		return false
+	}
+	status := k.daemonStatus(startedAt)
+	switch req.Command {
+	case daemonStatusCommand:
+		_ = json.NewEncoder(conn).Encode(daemonResponse{Status: status})
+	case daemonStopCommand:
+		_ = json.NewEncoder(conn).Encode(daemonResponse{Status: status})
+		return true
+	default:
+		_ = json.NewEncoder(conn).Encode(daemonResponse{Error: fmt.Sprintf("unknown command %s", req.Command)})
+	}
+	return false
+}
+
+// daemonStatus collects all connected ports and checks whether they still accept connections
+func (k *Environment) daemonStatus(startedAt time.Time) *DaemonStatus {
+	status := &DaemonStatus{
+		Pid:             os.Getpid(),
+		Namespace:       k.Namespace,
+		EnvironmentFile: k.Path,
+		StartedAt:       startedAt,
+		Forwards:        []ForwardStatus{},
+	}
+	if k.Config.Gateway != nil {
+		status.GatewayURL = k.Config.Gateway.URL
+	}
+	for chartName, chart := range k.Charts {
+		chart.ChartConnections.Range(func(key string, chartConnection *ChartConnection) bool {
+			local := chartConnection.snapshot()
+			for portName, localPort := range local.LocalPorts {
+				host := "localhost"
+				if h, ok := local.LocalHosts[portName]; ok {
+					host = h
+				}
+				status.Forwards = append(status.Forwards, forwardStatus(chartName, key, portName, host, localPort))
+			}
+			return true
+		})
+		chart.ServiceConnections.Range(func(key string, serviceConnection *ServiceConnection) bool {
+			local := serviceConnection.snapshot()
+			for portName, localPort := range local.LocalPorts {
+				host := "localhost"
+				if h, ok := local.LocalHosts[portName]; ok {
+					host = h
+				}
+				status.Forwards = append(status.Forwards, forwardStatus(chartName, "service/"+key, portName, host, localPort))
+			}
+			return true
+		})
+	}
+	sort.Slice(status.Forwards, func(i, j int) bool {
+		a, b := status.Forwards[i], status.Forwards[j]
+		return strings.Join([]string{a.Chart, a.Connection, a.Port}, "/") < strings.Join([]string{b.Chart, b.Connection, b.Port}, "/")
+	})
+	return status
+}
+
+func forwardStatus(chart, connection, port, host string, localPort int) ForwardStatus {
+	address := net.JoinHostPort(host, strconv.Itoa(localPort))
+	healthy := false
+	if conn, err := net.DialTimeout("tcp", address, time.Second); err == nil {
+		healthy = true
+		_ = conn.Close()
+	}
+	return ForwardStatus{
+		Chart:        chart,
+		Connection:   connection,
+		Port:         port,
+		LocalAddress: address,
+		Healthy:      healthy,
+	}
+}
+
+// QueryConnectDaemon returns the status of the connect daemon of an environment file
+func QueryConnectDaemon(environmentPath string) (*DaemonStatus, error) {
+	return sendDaemonCommand(environmentPath, daemonStatusCommand)
+}
+
+// StopConnectDaemon asks the connect daemon of an environment file to disconnect and waits for it to exit
+func StopConnectDaemon(environmentPath string) error {
+	files, err := GetDaemonFiles(environmentPath)
+	if err != nil {
+		return err
+	}
+	if _, err := sendDaemonCommand(environmentPath, daemonStopCommand); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(DaemonStopTimeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(files.PidFile); os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("timed out waiting for connect daemon to stop, pidfile %s still exists", files.PidFile)
+}
+
+func sendDaemonCommand(environmentPath string, command string) (*DaemonStatus, error) {
+	files, err := GetDaemonFiles(environmentPath)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", files.Socket, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("no connect daemon running for %s: %v", environmentPath, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(conn).Encode(daemonRequest{Command: command}); err != nil {
+		return nil, err
+	}
+	var resp daemonResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Status, nil
+}
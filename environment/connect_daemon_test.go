@@ -0,0 +1,57 @@
+package environment_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectDaemon(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	localPort := listener.Addr().(*net.TCPAddr).Port
+
+	e := &environment.Environment{Config: &environment.Config{
+		Path:      filepath.Join(t.TempDir(), "env.yaml"),
+		Namespace: "test-namespace",
+		Charts: environment.Charts{
+			"geth": &environment.HelmChart{
+				ChartConnections: environment.ChartConnections{
+					"geth_0_geth-network": &environment.ChartConnection{
+						RemotePorts: map[string]int{"http-rpc": 8544, "ws-rpc": 8546},
+						LocalPorts:  map[string]int{"http-rpc": localPort, "ws-rpc": 1},
+					},
+				},
+			},
+		},
+	}}
+	served := make(chan error, 1)
+	go func() {
+		served <- environment.ServeConnectDaemon(e, make(chan os.Signal))
+	}()
+
+	var status *environment.DaemonStatus
+	require.Eventually(t, func() bool {
+		status, err = environment.QueryConnectDaemon(e.Path)
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	require.Equal(t, "test-namespace", status.Namespace)
+	require.Equal(t, os.Getpid(), status.Pid)
+	require.Len(t, status.Forwards, 2)
+	require.Equal(t, "http-rpc", status.Forwards[0].Port)
+	require.True(t, status.Forwards[0].Healthy)
+	require.Equal(t, "ws-rpc", status.Forwards[1].Port)
+	require.False(t, status.Forwards[1].Healthy)
+
+	require.NoError(t, environment.StopConnectDaemon(e.Path))
+	require.NoError(t, <-served)
+	_, err = environment.QueryConnectDaemon(e.Path)
+	require.Error(t, err)
+}
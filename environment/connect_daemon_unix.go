@@ -0,0 +1,10 @@
+//go:build !windows
+
+package environment
+
+import "syscall"
+
+// detachedProcAttr starts the daemon in its own session so it outlives the terminal it was started from
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
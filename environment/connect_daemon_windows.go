@@ -0,0 +1,10 @@
+//go:build windows
+
+package environment
+
+import "syscall"
+
+// detachedProcAttr starts the daemon without a console so it outlives the terminal it was started from
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{HideWindow: true, CreationFlags: 0x00000008} // DETACHED_PROCESS
+}
@@ -0,0 +1,332 @@
+package environment
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConnectionQuery selects pod connections by chart, app, instance, container and port name, every filter that isn't
+// set matches everything
+type ConnectionQuery struct {
+	charts Charts
+
+	chart     *string
+	app       *string
+	instance  *int
+	container *string
+	portName  *string
+
+	remoteURLMode *string
+}
+
+// ConnectionPort a single port of a connection matched by a query, the remote address is the in-cluster host and port
+// in the remote URL mode of the query
+type ConnectionPort struct {
+	Chart         string
+	PortName      string
+	RemotePort    int
+	RemoteAddress string
+	LocalPort     int
+	Connection    *ChartConnection
+
+	local     *ChartConnection
+	remoteErr error
+}
+
+// Query starts a query over the connections of all charts
+func (c Charts) Query() *ConnectionQuery {
+	return &ConnectionQuery{charts: c}
+}
+
+// Query starts a query over the connections of a single chart
+func (cc ChartConnections) Query() *ConnectionQuery {
+	return &ConnectionQuery{charts: Charts{"": &HelmChart{ChartConnections: cc}}}
+}
+
+// Chart matches connections of the chart
+func (q *ConnectionQuery) Chart(chart string) *ConnectionQuery {
+	q.chart = &chart
+	return q
+}
+
+// App matches connections of the app, the value of the app label of the pod
+func (q *ConnectionQuery) App(app string) *ConnectionQuery {
+	q.app = &app
+	return q
+}
+
+// Instance matches connections of the app instance, pods of an app are enumerated from 0
+func (q *ConnectionQuery) Instance(instance int) *ConnectionQuery {
+	q.instance = &instance
+	return q
+}
+
+// Container matches connections of the container
+func (q *ConnectionQuery) Container(container string) *ConnectionQuery {
+	q.container = &container
+	return q
+}
+
+// Port matches the port name of a container
+func (q *ConnectionQuery) Port(portName string) *ConnectionQuery {
+	q.portName = &portName
+	return q
+}
+
+// RemoteURLMode sets how remote URLs address pods: by pod IP, service DNS or pod DNS name, by default it's the mode
+// of the chart or the environment
+func (q *ConnectionQuery) RemoteURLMode(mode string) *ConnectionQuery {
+	q.remoteURLMode = &mode
+	return q
+}
+
+// Connections returns the matched connections ordered by chart, app, instance and container
+func (q *ConnectionQuery) Connections() []*ChartConnection {
+	var connections []*ChartConnection
+	for _, match := range q.matches() {
+		connections = append(connections, match.connection)
+	}
+	return connections
+}
+
+// Ports returns every matched port ordered by chart, app, instance, container and port name
+func (q *ConnectionQuery) Ports() []ConnectionPort {
+	var ports []ConnectionPort
+	for _, match := range q.matches() {
+		portNames := make([]string, 0, len(match.snapshot.RemotePorts))
+		for portName := range match.snapshot.RemotePorts {
+			if q.portName == nil || *q.portName == portName {
+				portNames = append(portNames, portName)
+			}
+		}
+		sort.Strings(portNames)
+		mode := match.remoteURLMode
+		if q.remoteURLMode != nil {
+			mode = *q.remoteURLMode
+		}
+		for _, portName := range portNames {
+			port := ConnectionPort{
+				Chart:      match.chart,
+				PortName:   portName,
+				RemotePort: match.snapshot.RemotePorts[portName],
+				LocalPort:  match.snapshot.LocalPorts[portName],
+				Connection: match.connection,
+				local:      match.snapshot,
+			}
+			host, remotePort, err := match.snapshot.RemoteAddress(portName, mode)
+			if err != nil {
+				port.remoteErr = err
+			} else {
+				port.RemoteAddress = net.JoinHostPort(host, strconv.Itoa(remotePort))
+			}
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// PodNames returns the names of the matched pods, each pod once
+func (q *ConnectionQuery) PodNames() []string {
+	return q.unique(func(c *ChartConnection) string { return c.snapshot().PodName })
+}
+
+// PodIPs returns the IPs of the matched pods, each pod once
+func (q *ConnectionQuery) PodIPs() []string {
+	return q.unique(func(c *ChartConnection) string { return c.snapshot().PodIP })
+}
+
+// RemoteURLs returns the in-cluster URLs of the matched ports in the remote URL mode
+func (q *ConnectionQuery) RemoteURLs(protocol Protocol) ([]*url.URL, error) {
+	builder, err := protocol.builder()
+	if err != nil {
+		return nil, err
+	}
+	return q.RemoteURLsWith(builder)
+}
+
+// RemoteURL returns the in-cluster URL of the first matched port
+func (q *ConnectionQuery) RemoteURL(protocol Protocol) (*url.URL, error) {
+	return first(q.RemoteURLs(protocol))
+}
+
+// RemoteURLsWith returns the in-cluster URLs of the matched ports built by the URL builder
+func (q *ConnectionQuery) RemoteURLsWith(builder *URLBuilder) ([]*url.URL, error) {
+	ports := q.Ports()
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no connections found matching %s", q)
+	}
+	urls := make([]*url.URL, 0, len(ports))
+	for _, port := range ports {
+		if port.remoteErr != nil {
+			return nil, port.remoteErr
+		}
+		chartBuilder, err := builder.withChartCredentials(q.charts[port.Chart])
+		if err != nil {
+			return nil, err
+		}
+		u, err := chartBuilder.build(port.RemoteAddress, "")
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// RemoteURLWith returns the in-cluster URL of the first matched port built by the URL builder
+func (q *ConnectionQuery) RemoteURLWith(builder *URLBuilder) (*url.URL, error) {
+	return first(q.RemoteURLsWith(builder))
+}
+
+// LocalURLs returns the local URLs of the matched ports, all of them must be connected
+func (q *ConnectionQuery) LocalURLs(protocol Protocol) ([]*url.URL, error) {
+	builder, err := protocol.builder()
+	if err != nil {
+		return nil, err
+	}
+	return q.LocalURLsWith(builder)
+}
+
+// LocalURL returns the local URL of the first matched port
+func (q *ConnectionQuery) LocalURL(protocol Protocol) (*url.URL, error) {
+	return first(q.LocalURLs(protocol))
+}
+
+// LocalURLsWith returns the local URLs of the matched ports built by the URL builder, all of them must be connected
+func (q *ConnectionQuery) LocalURLsWith(builder *URLBuilder) ([]*url.URL, error) {
+	ports := q.Ports()
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no connections found matching %s", q)
+	}
+	urls := make([]*url.URL, 0, len(ports))
+	for _, port := range ports {
+		if port.LocalPort == 0 {
+			return nil, fmt.Errorf("port %s of pod %s isn't connected", port.PortName, port.local.PodName)
+		}
+		chartBuilder, err := builder.withChartCredentials(q.charts[port.Chart])
+		if err != nil {
+			return nil, err
+		}
+		u, err := chartBuilder.buildLocal(port.local.LocalHosts, port.local.LocalPaths, port.PortName, port.LocalPort)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// LocalURLWith returns the local URL of the first matched port built by the URL builder
+func (q *ConnectionQuery) LocalURLWith(builder *URLBuilder) (*url.URL, error) {
+	return first(q.LocalURLsWith(builder))
+}
+
+// String describes the filters of the query
+func (q *ConnectionQuery) String() string {
+	var filters []string
+	if q.chart != nil {
+		filters = append(filters, fmt.Sprintf("chart=%s", *q.chart))
+	}
+	if q.app != nil {
+		filters = append(filters, fmt.Sprintf("app=%s", *q.app))
+	}
+	if q.instance != nil {
+		filters = append(filters, fmt.Sprintf("instance=%d", *q.instance))
+	}
+	if q.container != nil {
+		filters = append(filters, fmt.Sprintf("container=%s", *q.container))
+	}
+	if q.portName != nil {
+		filters = append(filters, fmt.Sprintf("port=%s", *q.portName))
+	}
+	if len(filters) == 0 {
+		return "any connection"
+	}
+	return strings.Join(filters, ", ")
+}
+
+// connectionMatch a connection matched by a query, with a snapshot of it to read while a recreated pod changes it
+type connectionMatch struct {
+	chart         string
+	remoteURLMode string
+	connection    *ChartConnection
+	snapshot      *ChartConnection
+}
+
+func (q *ConnectionQuery) matches() []connectionMatch {
+	var matches []connectionMatch
+	for chartName, chart := range q.charts {
+		if q.chart != nil && *q.chart != chartName {
+			continue
+		}
+		chart.ChartConnections.Range(func(_ string, c *ChartConnection) bool {
+			if q.matchesConnection(c) {
+				snapshot := c.snapshot()
+				// queries of the connections of a single chart have no environment, the chart recorded its mode on them
+				mode := chart.remoteURLMode()
+				if mode == "" {
+					mode = snapshot.remoteURLMode
+				}
+				matches = append(matches, connectionMatch{chart: chartName, remoteURLMode: mode, connection: c, snapshot: snapshot})
+			}
+			return true
+		})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.chart != b.chart {
+			return a.chart < b.chart
+		}
+		if a.connection.App != b.connection.App {
+			return a.connection.App < b.connection.App
+		}
+		if a.connection.Instance != b.connection.Instance {
+			return a.connection.Instance < b.connection.Instance
+		}
+		return a.connection.Container < b.connection.Container
+	})
+	return matches
+}
+
+func (q *ConnectionQuery) matchesConnection(c *ChartConnection) bool {
+	if q.app != nil && *q.app != c.App {
+		return false
+	}
+	if q.instance != nil && *q.instance != c.Instance {
+		return false
+	}
+	if q.container != nil && *q.container != c.Container {
+		return false
+	}
+	if q.portName != nil {
+		if _, ok := c.RemotePorts[*q.portName]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *ConnectionQuery) unique(value func(c *ChartConnection) string) []string {
+	var values []string
+	seen := map[string]bool{}
+	for _, c := range q.Connections() {
+		v := value(c)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		values = append(values, v)
+	}
+	return values
+}
+
+func first(urls []*url.URL, err error) (*url.URL, error) {
+	if err != nil {
+		return nil, err
+	}
+	return urls[0], nil
+}
@@ -0,0 +1,84 @@
+package environment_test
+
+import (
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+)
+
+func queryTestCharts(t *testing.T) environment.Charts {
+	chainlink := environment.ChartConnections{}
+	for _, instance := range []string{"1", "0"} {
+		podIP := "10.0.0.1" + instance
+		require.NoError(t, chainlink.Store("chainlink_node", instance, "node", &environment.ChartConnection{
+			PodName:      "chainlink-node-" + instance,
+			PodIP:        podIP,
+			PodDNS:       "chainlink-node-" + instance + ".chainlink-service.env.svc.cluster.local",
+			ServiceHosts: map[string]string{"access": "chainlink-node.env.svc.cluster.local"},
+			ServicePorts: map[string]int{"access": 80},
+			RemotePorts:  map[string]int{"access": 6688},
+			LocalPorts:   map[string]int{"access": 50000 + len(chainlink)},
+		}))
+		require.NoError(t, chainlink.Store("chainlink_node", instance, "chainlink-db", &environment.ChartConnection{
+			PodName:     "chainlink-node-" + instance,
+			PodIP:       podIP,
+			RemotePorts: map[string]int{"postgres": 5432},
+		}))
+	}
+	geth := environment.ChartConnections{}
+	require.NoError(t, geth.Store("geth", "0", "geth-network", &environment.ChartConnection{
+		PodName:     "geth-0",
+		PodIP:       "10.0.0.2",
+		RemotePorts: map[string]int{"ws-rpc": 8546, "http-rpc": 8544},
+	}))
+	return environment.Charts{
+		"chainlink": &environment.HelmChart{ChartConnections: chainlink},
+		"geth":      &environment.HelmChart{ChartConnections: geth},
+	}
+}
+
+func TestConnectionQuery(t *testing.T) {
+	charts := queryTestCharts(t)
+
+	require.Equal(t, []string{"chainlink-node-0", "chainlink-node-1"}, charts.Query().App("chainlink_node").PodNames())
+	require.Equal(t, []string{"10.0.0.11"}, charts.Query().App("chainlink_node").Instance(1).PodIPs())
+	require.Len(t, charts.Query().Container("chainlink-db").Connections(), 2)
+	require.Len(t, charts.Query().Connections(), 5)
+
+	urls, err := charts.Query().App("chainlink_node").Port("access").RemoteURLs(environment.HTTP)
+	require.NoError(t, err)
+	require.Equal(t, "http://10.0.0.10:6688", urls[0].String())
+	require.Equal(t, "http://10.0.0.11:6688", urls[1].String())
+
+	u, err := charts["chainlink"].ChartConnections.Query().Instance(1).Port("access").LocalURL(environment.HTTP)
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:50000", u.String())
+
+	u, err = charts.Query().App("geth").Instance(0).Container("geth-network").Port("ws-rpc").RemoteURL(environment.WS)
+	require.NoError(t, err)
+	require.Equal(t, "ws://10.0.0.2:8546", u.String())
+
+	_, err = charts.Query().App("chainlink_node").Port("postgres").LocalURLs(environment.HTTP)
+	require.Error(t, err)
+	_, err = charts.Query().Chart("geth").Port("access").RemoteURL(environment.HTTP)
+	require.EqualError(t, err, "no connections found matching chart=geth, port=access")
+}
+
+func TestConnectionQueryRemoteURLModes(t *testing.T) {
+	charts := queryTestCharts(t)
+
+	u, err := charts.Query().App("chainlink_node").Instance(1).Port("access").RemoteURLMode(environment.PodDNSRemoteURLs).RemoteURL(environment.HTTP)
+	require.NoError(t, err)
+	require.Equal(t, "http://chainlink-node-1.chainlink-service.env.svc.cluster.local:6688", u.String())
+
+	charts["chainlink"].RemoteURLMode = environment.ServiceDNSRemoteURLs
+	u, err = charts.Query().App("chainlink_node").Port("access").RemoteURL(environment.HTTP)
+	require.NoError(t, err)
+	require.Equal(t, "http://chainlink-node.env.svc.cluster.local:80", u.String())
+
+	_, err = charts.Query().Chart("chainlink").Port("postgres").RemoteURL(environment.HTTP)
+	require.EqualError(t, err, "port postgres of pod chainlink-node-0 isn't exposed by any service")
+	_, err = charts.Query().Chart("geth").RemoteURLMode("node-ip").RemoteURL(environment.HTTP)
+	require.EqualError(t, err, "remote URL mode node-ip doesn't exist")
+}
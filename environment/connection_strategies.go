@@ -0,0 +1,295 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// PortForwardStrategy forwards pod and service ports to localhost through the k8s API server
+	PortForwardStrategy = "port-forward"
+	// NodePortStrategy connects through the node ports of NodePort and LoadBalancer services
+	NodePortStrategy = "node-port"
+	// IngressStrategy connects through the hosts and paths of the ingresses created by a release
+	IngressStrategy = "ingress"
+	// LoadBalancerStrategy connects through the external addresses of LoadBalancer services
+	LoadBalancerStrategy = "load-balancer"
+
+	// LoadBalancerTimeout how long to wait for a load balancer to get an external address assigned
+	LoadBalancerTimeout = 3 * time.Minute
+)
+
+// ConnectionStrategy exposes the ports of a deployed chart and records the externally reachable addresses in its
+// connections
+type ConnectionStrategy interface {
+	Connect(chart *HelmChart) error
+}
+
+// ConnectionStrategyFunc is an adapter to use ordinary functions as connection strategies
+type ConnectionStrategyFunc func(chart *HelmChart) error
+
+// Connect calls f(chart)
+func (f ConnectionStrategyFunc) Connect(chart *HelmChart) error {
+	return f(chart)
+}
+
+var connectionStrategies = map[string]ConnectionStrategy{
+	PortForwardStrategy:  ConnectionStrategyFunc(connectPortForward),
+	NodePortStrategy:     ConnectionStrategyFunc(connectNodePort),
+	IngressStrategy:      ConnectionStrategyFunc(connectIngress),
+	LoadBalancerStrategy: ConnectionStrategyFunc(connectLoadBalancer),
+}
+
+// RegisterConnectionStrategy makes a connection strategy available by name to charts and environment configs
+func RegisterConnectionStrategy(name string, strategy ConnectionStrategy) {
+	connectionStrategies[name] = strategy
+}
+
+// GetConnectionStrategy returns a registered connection strategy by name, an empty name is the port-forward strategy
+func GetConnectionStrategy(name string) (ConnectionStrategy, error) {
+	if name == "" {
+		name = PortForwardStrategy
+	}
+	strategy, ok := connectionStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("connection strategy %s doesn't exist", name)
+	}
+	return strategy, nil
+}
+
+// connectionStrategy resolves the strategy of the chart, falling back to the one of the environment
+func (hc *HelmChart) connectionStrategy() (ConnectionStrategy, error) {
+	name := hc.ConnectionStrategy
+	if name == "" && hc.env != nil {
+		name = hc.env.Config.ConnectionStrategy
+	}
+	return GetConnectionStrategy(name)
+}
+
+// connectPortForward forwards all pod containerPorts and services of the chart to localhost
+func connectPortForward(hc *HelmChart) error {
+	var rangeErr error
+	hc.ChartConnections.Range(func(key string, chartConnection *ChartConnection) bool {
+		rules, err := hc.makePortRules(chartConnection)
+		if err != nil {
+			rangeErr = err
+			return false
+		}
+		if err := hc.connectPod(chartConnection, rules); err != nil {
+			rangeErr = err
+			return false
+		}
+		return true
+	})
+	if rangeErr != nil {
+		return rangeErr
+	}
+	return hc.connectServices()
+}
+
+// connectNodePort exposes every service port that has a node port on the address of a cluster node,
+// node ports balance between all endpoints so every pod of a service gets the same address
+func connectNodePort(hc *HelmChart) error {
+	nodeAddress, err := hc.env.nodeAddress()
+	if err != nil {
+		return err
+	}
+	return hc.rangeReleaseServices(func(service *v1.Service) error {
+		for _, port := range service.Spec.Ports {
+			if port.NodePort == 0 {
+				continue
+			}
+			if err := hc.exposeServicePort(service, port, nodeAddress, int(port.NodePort), ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// connectLoadBalancer exposes every port of LoadBalancer services on the external address of the load balancer
+func connectLoadBalancer(hc *HelmChart) error {
+	return hc.rangeReleaseServices(func(service *v1.Service) error {
+		if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+			return nil
+		}
+		address, err := hc.env.loadBalancerAddress(service.Name)
+		if err != nil {
+			return err
+		}
+		for _, port := range service.Spec.Ports {
+			if err := hc.exposeServicePort(service, port, address, int(port.Port), ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// connectIngress exposes the service ports that are backends of the release ingresses on the ingress host and path
+func connectIngress(hc *HelmChart) error {
+	ingresses, err := hc.env.k8sClient.NetworkingV1().Ingresses(hc.namespaceName).List(context.Background(), metaV1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, ingress := range ingresses.Items {
+		if ingress.Annotations[HelmReleaseNameAnnotation] != hc.ReleaseName {
+			continue
+		}
+		tlsHosts := map[string]bool{}
+		for _, tls := range ingress.Spec.TLS {
+			for _, host := range tls.Hosts {
+				tlsHosts[host] = true
+			}
+		}
+		for _, rule := range ingress.Spec.Rules {
+			host := rule.Host
+			if host == "" {
+				for _, lb := range ingress.Status.LoadBalancer.Ingress {
+					host = lb.IP
+					if lb.Hostname != "" {
+						host = lb.Hostname
+					}
+				}
+			}
+			if host == "" || rule.HTTP == nil {
+				log.Warn().Str("Ingress", ingress.Name).Msg("Ingress rule has no reachable host, skipping")
+				continue
+			}
+			port := 80
+			if tlsHosts[rule.Host] {
+				port = 443
+			}
+			for _, path := range rule.HTTP.Paths {
+				backend := path.Backend.Service
+				if backend == nil {
+					continue
+				}
+				service, err := hc.env.k8sClient.CoreV1().Services(hc.namespaceName).Get(context.Background(), backend.Name, metaV1.GetOptions{})
+				if err != nil {
+					return errors.Wrapf(err, "failed to get backend service of ingress %s", ingress.Name)
+				}
+				for _, servicePort := range service.Spec.Ports {
+					byName := backend.Port.Name != "" && servicePort.Name == backend.Port.Name
+					byNumber := backend.Port.Number != 0 && servicePort.Port == backend.Port.Number
+					if !byName && !byNumber {
+						continue
+					}
+					if err := hc.exposeServicePort(service, servicePort, host, port, path.Path); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// rangeReleaseServices calls f for every service created by the release
+func (hc *HelmChart) rangeReleaseServices(f func(service *v1.Service) error) error {
+	serviceList, err := hc.env.k8sClient.CoreV1().Services(hc.namespaceName).List(context.Background(), metaV1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range serviceList.Items {
+		service := &serviceList.Items[i]
+		if service.Annotations[HelmReleaseNameAnnotation] != hc.ReleaseName {
+			continue
+		}
+		if err := f(service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exposeServicePort records an external address of a service port on the service connection and on every pod
+// connection that is an endpoint of it
+func (hc *HelmChart) exposeServicePort(service *v1.Service, port v1.ServicePort, host string, externalPort int, path string) error {
+	portName := servicePortName(port)
+	if serviceConnection, ok := hc.ServiceConnections[service.Name]; ok {
+		serviceConnection.setLocal(portName, host, externalPort, path)
+	}
+	endpoints, err := hc.env.k8sClient.CoreV1().Endpoints(hc.namespaceName).Get(context.Background(), service.Name, metaV1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for _, subset := range endpoints.Subsets {
+		for _, endpointPort := range subset.Ports {
+			if endpointPort.Name != port.Name {
+				continue
+			}
+			for _, address := range subset.Addresses {
+				if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+					continue
+				}
+				hc.ChartConnections.Range(func(_ string, chartConnection *ChartConnection) bool {
+					if chartConnection.snapshot().PodName != address.TargetRef.Name {
+						return true
+					}
+					for containerPortName, containerPort := range chartConnection.RemotePorts {
+						if containerPort == int(endpointPort.Port) {
+							chartConnection.setLocal(containerPortName, host, externalPort, path)
+						}
+					}
+					return true
+				})
+			}
+		}
+	}
+	log.Info().
+		Str("Service", service.Name).
+		Str("Port", portName).
+		Str("Address", fmt.Sprintf("%s:%d%s", host, externalPort, path)).
+		Msg("Exposed service port")
+	return nil
+}
+
+// nodeAddress returns the configured node address or the address of the first node, preferring external addresses
+func (k *Environment) nodeAddress() (string, error) {
+	if k.Config.NodeAddress != "" {
+		return k.Config.NodeAddress, nil
+	}
+	nodes, err := k.k8sClient.CoreV1().Nodes().List(context.Background(), metaV1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, addressType := range []v1.NodeAddressType{v1.NodeExternalIP, v1.NodeInternalIP} {
+		for _, node := range nodes.Items {
+			for _, address := range node.Status.Addresses {
+				if address.Type == addressType {
+					return address.Address, nil
+				}
+			}
+		}
+	}
+	return "", errors.New("no node address found, set node_address in the config")
+}
+
+// loadBalancerAddress waits for the load balancer of a service to get an external address assigned
+func (k *Environment) loadBalancerAddress(serviceName string) (string, error) {
+	deadline := time.Now().Add(LoadBalancerTimeout)
+	for time.Now().Before(deadline) {
+		service, err := k.k8sClient.CoreV1().Services(k.Namespace).Get(context.Background(), serviceName, metaV1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.Hostname != "" {
+				return ingress.Hostname, nil
+			}
+			if ingress.IP != "" {
+				return ingress.IP, nil
+			}
+		}
+		log.Debug().Str("Service", serviceName).Msg("Waiting for load balancer address")
+		time.Sleep(ServiceFailoverInterval)
+	}
+	return "", fmt.Errorf("timed out waiting for an external address of load balancer %s", serviceName)
+}
@@ -0,0 +1,195 @@
+package environment_test
+
+import (
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const strategyNamespace = "chainlink-abcde"
+
+func releaseMeta(name string) metaV1.ObjectMeta {
+	return metaV1.ObjectMeta{
+		Name:        name,
+		Namespace:   strategyNamespace,
+		Annotations: map[string]string{environment.HelmReleaseNameAnnotation: "chainlink"},
+	}
+}
+
+// strategyObjects a chainlink-node service with an http port backed by pod chainlink-node-0 on container port 6688
+func strategyObjects(serviceType v1.ServiceType) []runtime.Object {
+	service := &v1.Service{
+		ObjectMeta: releaseMeta("chainlink-node"),
+		Spec: v1.ServiceSpec{
+			Type:  serviceType,
+			Ports: []v1.ServicePort{{Name: "http", Port: 80, NodePort: 30080}},
+		},
+	}
+	if serviceType == v1.ServiceTypeClusterIP {
+		service.Spec.Ports[0].NodePort = 0
+	}
+	if serviceType == v1.ServiceTypeLoadBalancer {
+		service.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "203.0.113.10"}}
+	}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: metaV1.ObjectMeta{Name: "chainlink-node", Namespace: strategyNamespace},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "chainlink-node-0"}}},
+			Ports:     []v1.EndpointPort{{Name: "http", Port: 6688}},
+		}},
+	}
+	return []runtime.Object{service, endpoints}
+}
+
+func nodeWithAddresses(name string, addresses ...v1.NodeAddress) *v1.Node {
+	return &v1.Node{ObjectMeta: metaV1.ObjectMeta{Name: name}, Status: v1.NodeStatus{Addresses: addresses}}
+}
+
+func connectStrategyChart(t *testing.T, strategy string, nodeAddress string, objects ...runtime.Object) (*environment.HelmChart, error) {
+	e := environment.NewEnvironmentWithClient(&environment.Config{
+		Namespace:          strategyNamespace,
+		ConnectionStrategy: strategy,
+		NodeAddress:        nodeAddress,
+	}, fake.NewSimpleClientset(objects...))
+	chart := &environment.HelmChart{
+		ReleaseName: "chainlink",
+		Index:       1,
+		ChartConnections: environment.ChartConnections{
+			"chainlink-node_0_node": {
+				App:         "chainlink-node",
+				Container:   "node",
+				PodName:     "chainlink-node-0",
+				RemotePorts: map[string]int{"access": 6688, "p2p": 6690},
+			},
+		},
+		ServiceConnections: environment.ServiceConnections{
+			"chainlink-node": {ServiceName: "chainlink-node", RemotePorts: map[string]int{"http": 80}},
+		},
+	}
+	require.NoError(t, e.AddChart(chart))
+	return chart, chart.Connect()
+}
+
+func TestConnectionStrategies(t *testing.T) {
+	t.Parallel()
+	tlsIngress := &networkingV1.Ingress{
+		ObjectMeta: releaseMeta("chainlink"),
+		Spec: networkingV1.IngressSpec{
+			TLS: []networkingV1.IngressTLS{{Hosts: []string{"chainlink.example.com"}}},
+			Rules: []networkingV1.IngressRule{{
+				Host: "chainlink.example.com",
+				IngressRuleValue: networkingV1.IngressRuleValue{HTTP: &networkingV1.HTTPIngressRuleValue{
+					Paths: []networkingV1.HTTPIngressPath{{
+						Path: "/node",
+						Backend: networkingV1.IngressBackend{Service: &networkingV1.IngressServiceBackend{
+							Name: "chainlink-node",
+							Port: networkingV1.ServiceBackendPort{Name: "http"},
+						}},
+					}},
+				}},
+			}},
+		},
+	}
+	ingressWithoutHost := tlsIngress.DeepCopy()
+	ingressWithoutHost.Spec.TLS = nil
+	ingressWithoutHost.Spec.Rules[0].Host = ""
+	ingressWithoutHost.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port = networkingV1.ServiceBackendPort{Number: 80}
+	ingressWithoutHost.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "203.0.113.20"}}
+
+	tests := []struct {
+		name        string
+		strategy    string
+		nodeAddress string
+		objects     []runtime.Object
+		wantURL     string
+		wantErr     string
+	}{
+		{
+			name:     "node port prefers external node addresses",
+			strategy: environment.NodePortStrategy,
+			objects: append(strategyObjects(v1.ServiceTypeNodePort),
+				nodeWithAddresses("node-a", v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"}),
+				nodeWithAddresses("node-b", v1.NodeAddress{Type: v1.NodeExternalIP, Address: "198.51.100.2"}),
+			),
+			wantURL: "http://198.51.100.2:30080",
+		},
+		{
+			name:     "node port falls back to internal node addresses",
+			strategy: environment.NodePortStrategy,
+			objects: append(strategyObjects(v1.ServiceTypeNodePort),
+				nodeWithAddresses("node-a", v1.NodeAddress{Type: v1.NodeHostName, Address: "node-a"}, v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"}),
+			),
+			wantURL: "http://10.0.0.1:30080",
+		},
+		{
+			name:        "node port uses the configured node address",
+			strategy:    environment.NodePortStrategy,
+			nodeAddress: "node.example.com",
+			objects: append(strategyObjects(v1.ServiceTypeNodePort),
+				nodeWithAddresses("node-a", v1.NodeAddress{Type: v1.NodeExternalIP, Address: "198.51.100.2"}),
+			),
+			wantURL: "http://node.example.com:30080",
+		},
+		{
+			name:     "node port without node addresses",
+			strategy: environment.NodePortStrategy,
+			objects:  append(strategyObjects(v1.ServiceTypeNodePort), nodeWithAddresses("node-a")),
+			wantErr:  "no node address found, set node_address in the config",
+		},
+		{
+			name:     "load balancer",
+			strategy: environment.LoadBalancerStrategy,
+			objects:  strategyObjects(v1.ServiceTypeLoadBalancer),
+			wantURL:  "http://203.0.113.10:80",
+		},
+		{
+			name:     "ingress with tls host and path",
+			strategy: environment.IngressStrategy,
+			objects:  append(strategyObjects(v1.ServiceTypeClusterIP), tlsIngress),
+			wantURL:  "http://chainlink.example.com:443/node",
+		},
+		{
+			name:     "ingress without host uses the load balancer address",
+			strategy: environment.IngressStrategy,
+			objects:  append(strategyObjects(v1.ServiceTypeClusterIP), ingressWithoutHost),
+			wantURL:  "http://203.0.113.20:80/node",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			chart, err := connectStrategyChart(t, test.strategy, test.nodeAddress, test.objects...)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			podURL, err := chart.ChartConnections.LocalURLByPort("access", environment.HTTP)
+			require.NoError(t, err)
+			require.Equal(t, test.wantURL, podURL.String())
+			_, err = chart.ChartConnections.LocalURLByPort("p2p", environment.HTTP)
+			require.Error(t, err, "ports that aren't behind the service aren't exposed")
+
+			serviceURL, err := chart.ServiceConnections["chainlink-node"].LocalURL("http", environment.HTTP)
+			require.NoError(t, err)
+			require.Equal(t, test.wantURL, serviceURL.String())
+		})
+	}
+}
+
+func TestConnectionStrategiesSkipOtherReleases(t *testing.T) {
+	t.Parallel()
+	objects := strategyObjects(v1.ServiceTypeNodePort)
+	objects[0].(*v1.Service).Annotations[environment.HelmReleaseNameAnnotation] = "geth"
+	chart, err := connectStrategyChart(t, environment.NodePortStrategy, "node.example.com", objects...)
+	require.NoError(t, err)
+	_, err = chart.ChartConnections.LocalURLByPort("access", environment.HTTP)
+	require.Error(t, err)
+}
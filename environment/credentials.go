@@ -0,0 +1,133 @@
+package environment
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ReleaseNamePlaceholder is replaced by the release name of the chart in credential Secret names
+	ReleaseNamePlaceholder = "{{ .Release.Name }}"
+
+	redacted = "[REDACTED]"
+)
+
+// Credential refers to the key of a Secret that holds a credential of a chart port, e.g. the API password of a node
+type Credential struct {
+	Name   string `yaml:"name" json:"name" envconfig:"name"`
+	Secret string `yaml:"secret" json:"secret" envconfig:"secret"`
+	Key    string `yaml:"key" json:"key" envconfig:"key"`
+	Port   string `yaml:"port,omitempty" json:"port,omitempty" envconfig:"port"`
+}
+
+// Redacted is a resolved credential value, it's redacted when printed, logged or marshalled
+type Redacted string
+
+// Value returns the plain value
+func (s Redacted) Value() string {
+	return string(s)
+}
+
+// String redacts the value
+func (s Redacted) String() string {
+	return redacted
+}
+
+// GoString redacts the value
+func (s Redacted) GoString() string {
+	return redacted
+}
+
+// MarshalJSON redacts the value
+func (s Redacted) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
+
+// MarshalYAML redacts the value
+func (s Redacted) MarshalYAML() (interface{}, error) {
+	return redacted, nil
+}
+
+// PortCredentials resolved credentials of a connection keyed by port name and credential name
+type PortCredentials map[string]map[string]Redacted
+
+// defaultCredentials credentials of the embedded charts, keyed by chart directory
+var defaultCredentials = map[string][]*Credential{
+	"chainlink": {
+		{Name: "email", Secret: ReleaseNamePlaceholder + "-node-creds-secret", Key: "api-email", Port: "access"},
+		{Name: "password", Secret: ReleaseNamePlaceholder + "-node-creds-secret", Key: "api-password", Port: "access"},
+		{Name: "node-password", Secret: ReleaseNamePlaceholder + "-node-creds-secret", Key: "node-password"},
+		{Name: "db-username", Secret: ReleaseNamePlaceholder + "-node-creds-secret", Key: "db-username", Port: "postgres"},
+		{Name: "db-password", Secret: ReleaseNamePlaceholder + "-node-creds-secret", Key: "db-password", Port: "postgres"},
+	},
+	"localterra": {
+		{Name: "db-username", Secret: ReleaseNamePlaceholder + "-postgres-secret", Key: "username"},
+		{Name: "db-password", Secret: ReleaseNamePlaceholder + "-postgres-secret", Key: "password"},
+	},
+	"geth-reorg": {
+		{Name: "account-password", Secret: ReleaseNamePlaceholder + "-ethereum-geth-miner-secret", Key: "accountsecret", Port: "http-rpc"},
+	},
+}
+
+// setDefaultCredentials declares the credentials of embedded charts if none were declared
+func (hc *HelmChart) setDefaultCredentials() {
+	if len(hc.Credentials) > 0 {
+		return
+	}
+	chartDir := hc.ReleaseName
+	if hc.Path != "" {
+		chartDir = filepath.Base(hc.Path)
+	}
+	for _, c := range defaultCredentials[chartDir] {
+		credential := *c
+		hc.Credentials = append(hc.Credentials, &credential)
+	}
+}
+
+// secretName returns the name of the Secret of a credential for the release
+func (c *Credential) secretName(releaseName string) string {
+	return strings.ReplaceAll(c.Secret, ReleaseNamePlaceholder, releaseName)
+}
+
+// ResolveCredential reads a credential of the chart by name
+func (hc *HelmChart) ResolveCredential(name string) (Redacted, error) {
+	for _, c := range hc.Credentials {
+		if c.Name == name {
+			return hc.resolveCredential(c)
+		}
+	}
+	return "", fmt.Errorf("credential %s isn't declared for chart %s", name, hc.ReleaseName)
+}
+
+// ConnectionCredentials reads the credentials declared for the ports of a connection
+func (hc *HelmChart) ConnectionCredentials(connection *ChartConnection) (PortCredentials, error) {
+	credentials := PortCredentials{}
+	for _, c := range hc.Credentials {
+		if _, ok := connection.RemotePorts[c.Port]; !ok {
+			continue
+		}
+		value, err := hc.resolveCredential(c)
+		if err != nil {
+			return nil, err
+		}
+		if credentials[c.Port] == nil {
+			credentials[c.Port] = map[string]Redacted{}
+		}
+		credentials[c.Port][c.Name] = value
+	}
+	return credentials, nil
+}
+
+func (hc *HelmChart) resolveCredential(c *Credential) (Redacted, error) {
+	if hc.env == nil {
+		return "", fmt.Errorf("chart %s isn't initialised", hc.ReleaseName)
+	}
+	value, err := hc.env.GetSecretField(hc.namespaceName, c.secretName(hc.ReleaseName), c.Key)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve credential %s of chart %s", c.Name, hc.ReleaseName)
+	}
+	return Redacted(value), nil
+}
@@ -0,0 +1,24 @@
+package environment_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRedacted(t *testing.T) {
+	credentials := environment.PortCredentials{"access": {"password": environment.Redacted("hunter2")}}
+
+	require.Equal(t, "hunter2", credentials["access"]["password"].Value())
+	require.NotContains(t, fmt.Sprintf("%v %s %#v", credentials, credentials["access"]["password"], credentials), "hunter2")
+	j, err := json.Marshal(credentials)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"access":{"password":"[REDACTED]"}}`, string(j))
+	y, err := yaml.Marshal(credentials)
+	require.NoError(t, err)
+	require.NotContains(t, string(y), "hunter2")
+}
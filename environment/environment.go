@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"k8s.io/apimachinery/pkg/types"
@@ -49,9 +50,16 @@ type Environment struct {
 	Artifacts *Artifacts
 	Chaos     *chaos.Controller
 
-	k8sClient  *kubernetes.Clientset
-	k8sConfig  *rest.Config
-	forwarders []*portforward.PortForwarder
+	k8sClient    kubernetes.Interface
+	k8sConfig    *rest.Config
+	forwardersMu sync.Mutex
+	forwarders   []*podForwarder
+
+	serviceForwarders []*serviceForwarder
+	gateway           *Gateway
+	instanceWatcher   chan struct{}
+	// stateStore replaces the environment file and the store in the namespace when set
+	stateStore StateStore
 }
 
 // NewEnvironment creates new environment from charts
@@ -60,16 +68,27 @@ func NewEnvironment(config *Config) (*Environment, error) {
 	if err != nil {
 		return nil, err
 	}
+	defaultK8sConfig(config, kc)
+	return NewEnvironmentWithClientConfig(config, ks, kc), nil
+}
+
+// NewEnvironmentWithClient creates new environment from charts talking to k8s through the client, e.g. a fake
+// clientset, pods and services can't be port forwarded without the rest config of a cluster
+func NewEnvironmentWithClient(config *Config, client kubernetes.Interface) *Environment {
+	return NewEnvironmentWithClientConfig(config, client, nil)
+}
+
+// NewEnvironmentWithClientConfig creates new environment from charts talking to k8s through the client, pods and
+// services are port forwarded through the API server of the rest config
+func NewEnvironmentWithClientConfig(config *Config, client kubernetes.Interface, k8sConfig *rest.Config) *Environment {
 	if config.Charts == nil {
 		config.Charts = map[string]*HelmChart{}
 	}
-	defaultK8sConfig(config, kc)
-	he := &Environment{
+	return &Environment{
 		Config:    config,
-		k8sClient: ks,
-		k8sConfig: kc,
+		k8sClient: client,
+		k8sConfig: k8sConfig,
 	}
-	return he, nil
 }
 
 // DeployEnvironment returns a deployed environment from a given config that can be pre-defined within
@@ -162,19 +181,26 @@ func DeployRemoteRunnerToEnv(
 		Str("Namespace", env.Namespace).
 		Str("Reading from test Config File", env.Path).
 		Msg("Deploying test runner to run long-running test")
-	// Marshal env config as JSON to be able to connect to it from inside a pod
-	testConfigBytes, err := env.Config.ToJSON()
+	exeFile, err := os.Stat(testExecutablePath)
 	if err != nil {
 		return env, err
 	}
-	testConfigString := string(testConfigBytes)
-	exeFile, err := os.Stat(testExecutablePath)
+
+	// Add expected values into the map, the runner loads the config from the state store of the namespace if there is
+	// one, otherwise the config is marshalled as JSON to be able to connect to it from inside a pod
+	store, err := env.clusterStore()
 	if err != nil {
 		return env, err
 	}
-
-	// Add expected values into the map
-	runnerHelmValues["remote_test_runner"].(map[string]interface{})["config_file_contents"] = testConfigString
+	if store != nil {
+		runnerHelmValues["remote_test_runner"].(map[string]interface{})["state_store"] = env.Config.StateStore
+	} else {
+		testConfigBytes, err := env.Config.ToJSON()
+		if err != nil {
+			return env, err
+		}
+		runnerHelmValues["remote_test_runner"].(map[string]interface{})["config_file_contents"] = string(testConfigBytes)
+	}
 	runnerHelmValues["remote_test_runner"].(map[string]interface{})["test_file_size"] = exeFile.Size()
 
 	err = env.AddChart(&HelmChart{
@@ -236,10 +262,7 @@ func LoadEnvironment(config *Config) (*Environment, error) {
 		return nil, err
 	}
 	environment.Artifacts = artifacts
-	cc, err := chaos.NewController(&chaos.Config{
-		Client:        environment.k8sClient,
-		NamespaceName: config.Namespace,
-	})
+	cc, err := environment.newChaosController()
 	if err != nil {
 		return nil, err
 	}
@@ -270,9 +293,20 @@ func GetLocalK8sDeps() (*kubernetes.Clientset, *rest.Config, error) {
 // Disconnect closes any current open port forwarder rules
 func (k *Environment) Disconnect() {
 	log.Info().Str("Namespace", k.Namespace).Msg("Disconnecting all open forwarded ports")
+	k.StopInstanceWatcher()
+	if err := k.StopGateway(); err != nil {
+		log.Error().Err(err).Msg("Error while stopping the gateway")
+	}
+	k.forwardersMu.Lock()
 	for _, forwarder := range k.forwarders {
 		forwarder.Close()
 	}
+	k.forwarders = nil
+	k.forwardersMu.Unlock()
+	for _, forwarder := range k.serviceForwarders {
+		forwarder.Close()
+	}
+	k.serviceForwarders = nil
 }
 
 // Teardown tears down the helm releases
@@ -288,7 +322,11 @@ func (k *Environment) Teardown() error {
 	if err := k.removeNamespace(); err != nil {
 		return err
 	}
-	if err := k.SyncConfig(); err != nil {
+	// the state store in the namespace is removed with it
+	if _, inCluster := k.stateStore.(*ClusterStore); k.stateStore != nil && !inCluster {
+		return k.stateStore.Save(k.Config)
+	}
+	if err := k.syncConfigFile(); err != nil {
 		return err
 	}
 	return nil
@@ -317,10 +355,7 @@ func (k *Environment) Init(namespacePrefix string) error {
 		return err
 	}
 	k.Artifacts = a
-	cc, err := chaos.NewController(&chaos.Config{
-		Client:        k.k8sClient,
-		NamespaceName: k.Config.Namespace,
-	})
+	cc, err := k.newChaosController()
 	if err != nil {
 		return err
 	}
@@ -332,6 +367,7 @@ func (k *Environment) Init(namespacePrefix string) error {
 func (k *Environment) ClearConfig() error {
 	for _, chart := range k.Charts {
 		chart.ChartConnections = nil
+		chart.ServiceConnections = nil
 	}
 	k.Namespace = ""
 	if err := DumpConfig(k.Config, k.Path); err != nil {
@@ -342,38 +378,66 @@ func (k *Environment) ClearConfig() error {
 
 // ClearConfigLocalPorts removes the local ports set within config
 func (k *Environment) ClearConfigLocalPorts() error {
-	for _, chart := range k.Charts {
+	k.Config.ClearLocalPorts()
+	if err := DumpConfig(k.Config, k.Path); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ClearLocalPorts removes the local ports of connections and the gateway from the config
+func (m *Config) ClearLocalPorts() {
+	for _, chart := range m.Charts {
 		chart.ChartConnections.Range(func(_ string, chartConnection *ChartConnection) bool {
-			chartConnection.LocalPorts = nil
+			chartConnection.clearLocal()
+			return true
+		})
+		chart.ServiceConnections.Range(func(_ string, serviceConnection *ServiceConnection) bool {
+			serviceConnection.clearLocal()
 			return true
 		})
 	}
-	if err := DumpConfig(k.Config, k.Path); err != nil {
-		return err
+	if m.Gateway != nil {
+		m.Gateway.URL = ""
+		m.Gateway.Routes = nil
 	}
-	return nil
 }
 
-// SyncConfig dumps config in Persistent mode
+// SyncConfig dumps config in Persistent mode, and saves it to the state store in the namespace if there is one. An
+// environment with its own state store only saves the config to it
 func (k *Environment) SyncConfig() error {
+	if k.stateStore != nil {
+		return k.stateStore.Save(k.Config)
+	}
+	if err := k.syncConfigFile(); err != nil {
+		return err
+	}
+	return k.syncClusterState()
+}
+
+// SyncConfigJson dumps a json config in Persistent mode, and saves it to the state store in the namespace if there
+// is one. An environment with its own state store only saves the config to it
+func (k *Environment) SyncConfigJson() error {
+	if k.stateStore != nil {
+		return k.stateStore.Save(k.Config)
+	}
 	if k.Config.Persistent {
-		if len(k.Path) == 0 || strings.HasSuffix(k.Path, ".json") {
-			k.Path = fmt.Sprintf("%s.yaml", k.Namespace)
+		if len(k.Path) == 0 || strings.HasSuffix(k.Path, ".yaml") {
+			k.Path = fmt.Sprintf("%s.json", k.Namespace)
 		}
-		if err := DumpConfig(k.Config, k.Path); err != nil {
+		if err := (&FileStore{Path: k.Path}).Save(k.Config); err != nil {
 			return err
 		}
 	}
-	return nil
+	return k.syncClusterState()
 }
 
-// SyncConfigJson dumps a json config in Persistent mode
-func (k *Environment) SyncConfigJson() error {
+func (k *Environment) syncConfigFile() error {
 	if k.Config.Persistent {
-		if len(k.Path) == 0 || strings.HasSuffix(k.Path, ".yaml") {
-			k.Path = fmt.Sprintf("%s.json", k.Namespace)
+		if len(k.Path) == 0 || strings.HasSuffix(k.Path, ".json") {
+			k.Path = fmt.Sprintf("%s.yaml", k.Namespace)
 		}
-		if err := DumpConfigJson(k.Config, k.Path); err != nil {
+		if err := (&FileStore{Path: k.Path}).Save(k.Config); err != nil {
 			return err
 		}
 	}
@@ -391,6 +455,9 @@ func (k *Environment) Deploy(chartName string) error {
 
 // DeployAll deploys all deploy sequence at once
 func (k *Environment) DeployAll() error {
+	if err := k.Charts.CheckReferences(); err != nil {
+		return err
+	}
 	for _, keySlice := range k.Charts.OrderedKeys() {
 		group := &errgroup.Group{}
 		for _, key := range keySlice {
@@ -449,27 +516,53 @@ func (k *Environment) Connect(chartName string) error {
 	return chart.Connect()
 }
 
-// ConnectAll connects to all containerPorts for all charts, dump config in JSON if Persistent flag is present
+// ConnectAll connects to all containerPorts for all charts, keeps instance labels of recreated pods stable, starts
+// the gateway if it's enabled, dump config in JSON if Persistent flag is present
 func (k *Environment) ConnectAll() error {
 	for _, c := range k.Charts {
 		if err := c.Connect(); err != nil {
 			return err
 		}
 	}
+	if k.Config.Gateway != nil && k.Config.Gateway.Enabled {
+		if _, err := k.StartGateway(); err != nil {
+			return err
+		}
+	}
+	k.StartInstanceWatcher()
 	if err := k.SyncConfig(); err != nil {
 		return err
 	}
 	return nil
 }
 
+// IsPortForwarded returns true if any of the charts is connected by forwarding ports to local, these connections only
+// last as long as the process
+func (k *Environment) IsPortForwarded() bool {
+	for _, c := range k.Charts {
+		name := c.ConnectionStrategy
+		if name == "" {
+			name = k.Config.ConnectionStrategy
+		}
+		if name == "" || name == PortForwardStrategy {
+			return true
+		}
+	}
+	return false
+}
+
 // GetSecretField retrieves field data from k8s secret
 func (k *Environment) GetSecretField(namespace string, secretName string, fieldName string) (string, error) {
 	res, err := k.k8sClient.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metaV1.GetOptions{})
-	log.Debug().Interface("Data", res.Data).Send()
 	if err != nil {
 		return "", err
 	}
-	return string(res.Data[fieldName]), nil
+	value, ok := res.Data[fieldName]
+	if !ok {
+		return "", fmt.Errorf("field %s doesn't exist in secret %s", fieldName, secretName)
+	}
+	log.Debug().Str("Secret", secretName).Str("Field", fieldName).Msg("Read secret field")
+	return string(value), nil
 }
 
 func (k *Environment) createNamespace(namespacePrefix string) error {
@@ -545,11 +638,87 @@ func (k *Environment) removeNamespace() error {
 
 // runGoForwarder runs port forwarder as a goroutine
 func (k *Environment) runGoForwarder(chartConnection *ChartConnection, portRules []string, portForwardTimeout time.Duration) error {
-	podName := chartConnection.PodName
-	roundTripper, upgrader, err := spdy.RoundTripperFor(k.k8sConfig)
+	forwarder, forwardedPorts, err := k.forwardPodPorts(chartConnection.snapshot().PodName, portRules, portForwardTimeout)
 	if err != nil {
 		return err
 	}
+	k.forwardersMu.Lock()
+	k.forwarders = append(k.forwarders, forwarder)
+	k.forwardersMu.Unlock()
+	chartConnection.mu.Lock()
+	defer chartConnection.mu.Unlock()
+	chartConnection.forwarder = forwarder
+	for portName, port := range chartConnection.RemotePorts {
+		for _, forwardedPort := range forwardedPorts {
+			fpr := int(forwardedPort.Remote)
+			if port == fpr {
+				if chartConnection.LocalPorts == nil {
+					chartConnection.LocalPorts = map[string]int{}
+				}
+				fpl := int(forwardedPort.Local)
+				chartConnection.LocalPorts[portName] = fpl
+			}
+		}
+	}
+	return nil
+}
+
+// closeForwarder stops forwarding to a pod and stops tracking the forwarder
+func (k *Environment) closeForwarder(forwarder *podForwarder) {
+	forwarder.Close()
+	k.forwardersMu.Lock()
+	defer k.forwardersMu.Unlock()
+	for i, f := range k.forwarders {
+		if f == forwarder {
+			k.forwarders = append(k.forwarders[:i], k.forwarders[i+1:]...)
+			return
+		}
+	}
+}
+
+// reforwardPod forwards the ports of a connection to its current pod, on the local ports they were forwarded to
+// before so the already handed out local URLs keep working
+func (k *Environment) reforwardPod(chartConnection *ChartConnection, portForwardTimeout time.Duration) error {
+	connection := chartConnection.snapshot()
+	rules := make([]string, 0)
+	for portName, port := range connection.RemotePorts {
+		rules = append(rules, fmt.Sprintf("%d:%d", connection.LocalPorts[portName], port))
+	}
+	return k.runGoForwarder(chartConnection, rules, portForwardTimeout)
+}
+
+// podForwarder is a port forwarder to a single pod that can be stopped
+type podForwarder struct {
+	*portforward.PortForwarder
+	stopChan  chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// Close stops forwarding and closes all local listeners
+func (f *podForwarder) Close() {
+	f.closeOnce.Do(func() {
+		close(f.stopChan)
+	})
+	f.PortForwarder.Close()
+}
+
+// Stopped returns a channel that is closed once the forwarder stops, either by closing it or by losing the
+// connection to the pod
+func (f *podForwarder) Stopped() <-chan struct{} {
+	return f.stopped
+}
+
+// forwardPodPorts starts forwarding the port rules to a pod and blocks until the forwarder is ready
+func (k *Environment) forwardPodPorts(
+	podName string,
+	portRules []string,
+	portForwardTimeout time.Duration,
+) (*podForwarder, []portforward.ForwardedPort, error) {
+	roundTripper, upgrader, err := spdy.RoundTripperFor(k.k8sConfig)
+	if err != nil {
+		return nil, nil, err
+	}
 	httpPath := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", k.Config.Namespace, podName)
 	hostIP := strings.TrimLeft(k.k8sConfig.Host, "htps:/")
 	serverURL := url.URL{Scheme: "https", Path: httpPath, Host: hostIP}
@@ -565,9 +734,11 @@ func (k *Environment) runGoForwarder(chartConnection *ChartConnection, portRules
 
 	forwarder, err := portforward.New(dialer, portRules, stopChan, readyChan, out, errOut)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	pf := &podForwarder{PortForwarder: forwarder, stopChan: stopChan, stopped: make(chan struct{})}
 	go func() {
+		defer close(pf.stopped)
 		if err := forwarder.ForwardPorts(); err != nil {
 			log.Error().Str("Pod", podName).Err(err)
 		}
@@ -577,34 +748,34 @@ func (k *Environment) runGoForwarder(chartConnection *ChartConnection, portRules
 	case <-readyChan:
 		break
 	case <-time.After(portForwardTimeout):
-		return errors.New("Timed out waiting for port forwarding")
+		pf.Close()
+		return nil, nil, errors.New("Timed out waiting for port forwarding")
 	}
 
 	if len(errOut.String()) > 0 {
-		return fmt.Errorf("error on forwarding k8s port: %v", errOut.String())
+		pf.Close()
+		return nil, nil, fmt.Errorf("error on forwarding k8s port: %v", errOut.String())
 	}
 	if len(out.String()) > 0 {
 		msg := strings.ReplaceAll(out.String(), "\n", " ")
 		log.Info().Str("Pod", podName).Msgf("%s", msg)
 	}
-	k.forwarders = append(k.forwarders, forwarder)
 	forwardedPorts, err := forwarder.GetPorts()
 	if err != nil {
-		return err
-	}
-	for portName, port := range chartConnection.RemotePorts {
-		for _, forwardedPort := range forwardedPorts {
-			fpr := int(forwardedPort.Remote)
-			if port == fpr {
-				if chartConnection.LocalPorts == nil {
-					chartConnection.LocalPorts = map[string]int{}
-				}
-				fpl := int(forwardedPort.Local)
-				chartConnection.LocalPorts[portName] = fpl
-			}
-		}
+		pf.Close()
+		return nil, nil, err
 	}
-	return nil
+	return pf, forwardedPorts, nil
+}
+
+// newChaosController creates the chaos controller of the namespace, experiments are only run with a clientset of a
+// cluster
+func (k *Environment) newChaosController() (*chaos.Controller, error) {
+	clientset, _ := k.k8sClient.(*kubernetes.Clientset)
+	return chaos.NewController(&chaos.Config{
+		Client:        clientset,
+		NamespaceName: k.Config.Namespace,
+	})
 }
 
 func defaultK8sConfig(config *Config, kc *rest.Config) {
@@ -52,13 +52,16 @@ func TestCanDeployAll(t *testing.T) {
 	err = e.ConnectAll()
 	require.NoError(t, err)
 
-	require.NotEmpty(t, e.Config.Charts["geth"].ChartConnections["geth_0_geth-network"].RemotePorts["ws-rpc"])
-	require.NotEmpty(t, e.Config.Charts["geth"].ChartConnections["geth_0_geth-network"].LocalPorts["ws-rpc"])
-
-	require.NotEmpty(t, e.Config.Charts["chainlink"].ChartConnections["chainlink-node_0_node"].RemotePorts["access"])
-	require.NotEmpty(t, e.Config.Charts["chainlink"].ChartConnections["chainlink-node_0_node"].LocalPorts["access"])
-	require.NotEmpty(t, e.Config.Charts["chainlink"].ChartConnections["chainlink-node_0_chainlink-db"].RemotePorts["postgres"])
-	require.NotEmpty(t, e.Config.Charts["chainlink"].ChartConnections["chainlink-node_0_chainlink-db"].LocalPorts["postgres"])
+	for _, query := range []*environment.ConnectionQuery{
+		e.Config.Charts.Query().Chart("geth").App("geth").Instance(0).Port("ws-rpc"),
+		e.Config.Charts.Query().Chart("chainlink").App("chainlink-node").Instance(0).Container("node").Port("access"),
+		e.Config.Charts.Query().Chart("chainlink").App("chainlink-node").Instance(0).Container("chainlink-db").Port("postgres"),
+	} {
+		_, err = query.RemoteURL(environment.HTTP)
+		require.NoError(t, err)
+		_, err = query.LocalURL(environment.HTTP)
+		require.NoError(t, err)
+	}
 }
 
 func TestMultipleChartsSeparate(t *testing.T) {
@@ -100,6 +103,7 @@ func TestMultipleChartsSeparate(t *testing.T) {
 	require.NotEmpty(t, e.Config.Charts["chainlink"].ChartConnections["chainlink-node_0_node"].LocalPorts["access"])
 	require.NotEmpty(t, e.Config.Charts["chainlink"].ChartConnections["chainlink-node_0_chainlink-db"].RemotePorts["postgres"])
 	require.NotEmpty(t, e.Config.Charts["chainlink"].ChartConnections["chainlink-node_0_chainlink-db"].LocalPorts["postgres"])
+	require.Equal(t, &environment.Workload{Kind: "Deployment", Name: "chainlink-node"}, e.Config.Charts["chainlink"].ChartConnections["chainlink-node_0_node"].Workload)
 }
 
 func TestDeployRepositoryChart(t *testing.T) {
@@ -289,6 +293,36 @@ func TestAutoConnect(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestConnectService(t *testing.T) {
+	t.Parallel()
+
+	envName := fmt.Sprintf("test-env-%s", uuid.NewV4().String())
+	e, err := environment.NewEnvironment(&environment.Config{})
+	defer teardown(t, e)
+	require.NoError(t, err)
+	err = e.Init(envName)
+	require.NoError(t, err)
+
+	err = e.AddChart(&environment.HelmChart{
+		ReleaseName: "geth",
+		Path:        filepath.Join(tools.ChartsRoot, "geth"),
+		Index:       1,
+	})
+	require.NoError(t, err)
+	err = e.Deploy("geth")
+	require.NoError(t, err)
+	err = e.Connect("geth")
+	require.NoError(t, err)
+
+	service, err := e.Charts.Services("geth").Load("geth")
+	require.NoError(t, err)
+	require.NotEmpty(t, service.RemotePorts["ws-rpc"])
+	require.NotEmpty(t, service.LocalPorts["ws-rpc"])
+	require.NotEmpty(t, service.PodName)
+	_, err = service.LocalURL("http-rpc", environment.HTTP)
+	require.NoError(t, err)
+}
+
 func TestCanConnectProgrammatically(t *testing.T) {
 	t.Parallel()
 	// TODO
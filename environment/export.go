@@ -0,0 +1,179 @@
+package environment
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExportFormat format of exported connection variables
+type ExportFormat string
+
+const (
+	// DotenvFormat KEY=value lines, as read by .env loaders
+	DotenvFormat ExportFormat = "dotenv"
+	// ShellFormat export KEY='value' lines, to be sourced by a shell
+	ShellFormat ExportFormat = "shell"
+	// JSONFormat a flat JSON object of all variables
+	JSONFormat ExportFormat = "json"
+)
+
+var nonVariableChars = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// variableName upper cases the parts and joins them with underscores, replacing all non-alphanumeric chars
+func variableName(parts ...string) string {
+	name := strings.ToUpper(strings.Join(parts, "_"))
+	return strings.Trim(nonVariableChars.ReplaceAllString(name, "_"), "_")
+}
+
+// variablePrefix names variables after the app, prefixed with the chart name unless the app already is
+func variablePrefix(chartName, app string) string {
+	if strings.HasPrefix(app, chartName) {
+		return variableName(app)
+	}
+	return variableName(chartName, app)
+}
+
+// ConnectionVariables flattens every chart, app, instance and port into variables holding local and remote addresses
+// and URLs, e.g. CHAINLINK_NODE_0_ACCESS_HTTP_URL for the local URL of the access port of the first chainlink node
+func (c Charts) ConnectionVariables() map[string]string {
+	vars := map[string]string{}
+	for chartName, chart := range c {
+		// containers of the same pod can share port names, those are told apart by the container name
+		portNames := map[string]int{}
+		chart.ChartConnections.Range(func(key string, chartConnection *ChartConnection) bool {
+			app, instance, _, _ := parseMapKey(key)
+			for portName := range chartConnection.RemotePorts {
+				portNames[variableName(variablePrefix(chartName, app), instance, portName)]++
+			}
+			return true
+		})
+		chart.ChartConnections.Range(func(key string, c *ChartConnection) bool {
+			app, instance, container, ok := parseMapKey(key)
+			if !ok {
+				return true
+			}
+			// a recreated pod may change the connection meanwhile
+			chartConnection := c.snapshot()
+			instancePrefix := variableName(variablePrefix(chartName, app), instance)
+			vars[variableName(instancePrefix, "POD_NAME")] = chartConnection.PodName
+			vars[variableName(instancePrefix, "POD_IP")] = chartConnection.PodIP
+			for portName := range chartConnection.RemotePorts {
+				portPrefix := variableName(instancePrefix, portName)
+				if portNames[portPrefix] > 1 {
+					portPrefix = variableName(instancePrefix, container, portName)
+				}
+				// ports without an address in the remote URL mode of the chart only get local variables
+				remoteHost, remotePort, err := chartConnection.RemoteAddress(portName, chart.remoteURLMode())
+				if err != nil {
+					remoteHost = ""
+				}
+				localPort := chartConnection.LocalPorts[portName]
+				addPortVariables(vars, portPrefix, remoteHost, remotePort, localPort, func(scheme string) string {
+					u := &url.URL{Scheme: scheme, Host: fmt.Sprintf("localhost:%d", localPort)}
+					chartConnection.localize(u, portName)
+					return u.String()
+				})
+			}
+			return true
+		})
+		chart.ServiceConnections.Range(func(_ string, serviceConnection *ServiceConnection) bool {
+			servicePrefix := variableName(variablePrefix(chartName, serviceConnection.ServiceName), "SERVICE")
+			local := serviceConnection.snapshot()
+			for portName, remotePort := range serviceConnection.RemotePorts {
+				localPort := local.LocalPorts[portName]
+				addPortVariables(vars, variableName(servicePrefix, portName), serviceConnection.ServiceName, remotePort, localPort, func(scheme string) string {
+					u := &url.URL{Scheme: scheme, Host: fmt.Sprintf("localhost:%d", localPort)}
+					localizeURL(u, local.LocalHosts, local.LocalPaths, portName)
+					return u.String()
+				})
+			}
+			return true
+		})
+	}
+	return vars
+}
+
+// addPortVariables adds the remote address and URLs of a port if it has a remote host, and the local ones if the port
+// is connected
+func addPortVariables(
+	vars map[string]string,
+	prefix string,
+	remoteHost string,
+	remotePort int,
+	localPort int,
+	localURL func(scheme string) string,
+) {
+	if remoteHost != "" {
+		vars[variableName(prefix, "REMOTE_PORT")] = strconv.Itoa(remotePort)
+		for _, scheme := range []string{"http", "ws"} {
+			vars[variableName(prefix, "REMOTE", scheme, "URL")] = fmt.Sprintf("%s://%s:%d", scheme, remoteHost, remotePort)
+		}
+	}
+	if localPort == 0 {
+		return
+	}
+	vars[variableName(prefix, "LOCAL_PORT")] = strconv.Itoa(localPort)
+	for _, scheme := range []string{"http", "ws"} {
+		vars[variableName(prefix, scheme, "URL")] = localURL(scheme)
+	}
+}
+
+// ConnectionVariables flattens the connection details of the environment into variables, see Charts.ConnectionVariables
+func (k *Environment) ConnectionVariables() map[string]string {
+	vars := k.Charts.ConnectionVariables()
+	vars["HELMENV_NAMESPACE"] = k.Namespace
+	if k.Config.Gateway != nil && k.Config.Gateway.URL != "" {
+		vars["HELMENV_GATEWAY_URL"] = k.Config.Gateway.URL
+	}
+	return vars
+}
+
+// ExportConnections writes the connection variables of the environment in the given format
+func (k *Environment) ExportConnections(w io.Writer, format ExportFormat) error {
+	return WriteVariables(w, k.ConnectionVariables(), format)
+}
+
+// WriteVariables writes variables in the given format, sorted by name
+func WriteVariables(w io.Writer, vars map[string]string, format ExportFormat) error {
+	switch format {
+	case DotenvFormat, ShellFormat:
+	case JSONFormat:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(vars)
+	default:
+		return fmt.Errorf("export format %s doesn't exist, use %s, %s or %s", format, DotenvFormat, ShellFormat, JSONFormat)
+	}
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		line := fmt.Sprintf("%s=%s\n", key, dotenvQuote(vars[key]))
+		if format == ShellFormat {
+			line = fmt.Sprintf("export %s=%s\n", key, shellQuote(vars[key]))
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dotenvQuote(value string) string {
+	if strings.ContainsAny(value, " \t\n\"'#$\\") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
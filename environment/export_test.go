@@ -0,0 +1,14 @@
+package environment
+
+// UpdateWorkloadConnections stores the connections of the pods owned by the resources like a deployment does,
+// without reading the resources from the manifest of a release
+func (hc *HelmChart) UpdateWorkloadConnections(resources ...Workload) error {
+	manifest := map[Workload]bool{}
+	for _, resource := range resources {
+		manifest[resource] = true
+	}
+	if err := hc.fetchWorkloadPods(manifest); err != nil {
+		return err
+	}
+	return hc.updateChartSettings()
+}
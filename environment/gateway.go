@@ -0,0 +1,210 @@
+package environment
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// GatewayConfig configures the local gateway that proxies HTTP and WebSocket requests to all connected ports of an
+// environment through a single local port
+type GatewayConfig struct {
+	Enabled bool              `yaml:"enabled" json:"enabled" envconfig:"enabled"`
+	Port    int               `yaml:"port,omitempty" json:"port,omitempty" envconfig:"port"`
+	URL     string            `yaml:"url,omitempty" json:"url,omitempty" envconfig:"url"`
+	Routes  map[string]string `yaml:"routes,omitempty" json:"routes,omitempty" envconfig:"routes"`
+}
+
+// Gateway is a local HTTP and WebSocket proxy routing /<chart>/<app>/<instance>/<port>/... to the connected port
+type Gateway struct {
+	URL    *url.URL
+	routes map[string]*url.URL
+
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewGateway creates a gateway for all the connected ports of the charts, listening on the port, 0 picks a free port.
+// Containers of the same pod can share port names, those are told apart by the container name in the route
+func NewGateway(charts Charts, port int) (*Gateway, error) {
+	routes := map[string]*url.URL{}
+	for _, chartName := range sortedKeys(charts) {
+		chart := charts[chartName]
+		var connections []*ChartConnection
+		portNames := map[string]int{}
+		for _, key := range sortedKeys(chart.ChartConnections) {
+			// a recreated pod may change the connection meanwhile
+			chartConnection := chart.ChartConnections[key].snapshot()
+			if chartConnection.App == "" {
+				log.Warn().Str("Connection", key).Msg("Unable to route connection through the gateway")
+				continue
+			}
+			connections = append(connections, chartConnection)
+			for portName := range chartConnection.LocalPorts {
+				portNames[gatewayRoute(chartName, chartConnection.App, strconv.Itoa(chartConnection.Instance), portName)]++
+			}
+		}
+		for _, chartConnection := range connections {
+			instance := strconv.Itoa(chartConnection.Instance)
+			for portName, localPort := range chartConnection.LocalPorts {
+				route := gatewayRoute(chartName, chartConnection.App, instance, portName)
+				if portNames[route] > 1 {
+					route = gatewayRoute(chartName, chartConnection.App, instance, chartConnection.Container, portName)
+				}
+				target, err := url.Parse(fmt.Sprintf("http://localhost:%d", localPort))
+				if err != nil {
+					return nil, err
+				}
+				localizeURL(target, chartConnection.LocalHosts, chartConnection.LocalPaths, portName)
+				routes[route] = target
+			}
+		}
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	g := &Gateway{
+		URL:      &url.URL{Scheme: "http", Host: listener.Addr().String()},
+		routes:   routes,
+		listener: listener,
+	}
+	g.server = &http.Server{Handler: g, ReadHeaderTimeout: 30 * time.Second}
+	return g, nil
+}
+
+// Start serves the gateway in the background
+func (g *Gateway) Start() {
+	go func() {
+		if err := g.server.Serve(g.listener); err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("Gateway stopped")
+		}
+	}()
+}
+
+// Close stops the gateway
+func (g *Gateway) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return g.server.Shutdown(ctx)
+}
+
+// Routes returns the gateway URLs mapped to the URLs they proxy to
+func (g *Gateway) Routes() map[string]string {
+	routes := map[string]string{}
+	for route, target := range g.routes {
+		routes[g.URL.String()+route] = target.String()
+	}
+	return routes
+}
+
+// RoutingTable returns a printable table of all the gateway routes
+func (g *Gateway) RoutingTable() string {
+	routes := g.Routes()
+	keys := make([]string, 0, len(routes))
+	for route := range routes {
+		keys = append(keys, route)
+	}
+	sort.Strings(keys)
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROUTE\tTARGET")
+	for _, route := range keys {
+		fmt.Fprintf(w, "%s\t%s\n", route, routes[route])
+	}
+	_ = w.Flush()
+	return buf.String()
+}
+
+// ServeHTTP proxies the request to the port addressed by the first four path segments, or five when the route names
+// the container, WebSocket upgrades included
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 6)
+	if len(segments) < 4 {
+		http.Error(w, "route must be in form of /<chart>/<app>/<instance>/<port>/...", http.StatusNotFound)
+		return
+	}
+	var route, rest string
+	var target *url.URL
+	var ok bool
+	if len(segments) >= 5 {
+		route = gatewayRoute(segments[:5]...)
+		if target, ok = g.routes[route]; ok && len(segments) == 6 {
+			rest = segments[5]
+		}
+	}
+	if !ok {
+		route = gatewayRoute(segments[:4]...)
+		if target, ok = g.routes[route]; !ok {
+			http.Error(w, fmt.Sprintf("no connection for route %s", route), http.StatusNotFound)
+			return
+		}
+		rest = strings.Join(segments[4:], "/")
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = path.Join("/", target.Path, rest)
+			if strings.HasSuffix(r.URL.Path, "/") && !strings.HasSuffix(req.URL.Path, "/") {
+				req.URL.Path += "/"
+			}
+			req.URL.RawPath = ""
+			req.Host = target.Host
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// gatewayRoute joins the chart, app, instance, container if needed and port name into a route
+func gatewayRoute(segments ...string) string {
+	return "/" + strings.Join(segments, "/")
+}
+
+// StartGateway starts the local gateway for all connected charts and records its routes in the config
+func (k *Environment) StartGateway() (*Gateway, error) {
+	if k.Config.Gateway == nil {
+		k.Config.Gateway = &GatewayConfig{Enabled: true}
+	}
+	if k.gateway != nil {
+		if err := k.gateway.Close(); err != nil {
+			return nil, err
+		}
+	}
+	g, err := NewGateway(k.Charts, k.Config.Gateway.Port)
+	if err != nil {
+		return nil, err
+	}
+	g.Start()
+	k.gateway = g
+	k.Config.Gateway.URL = g.URL.String()
+	k.Config.Gateway.Routes = g.Routes()
+	log.Info().Str("URL", g.URL.String()).Msgf("Gateway started\n%s", g.RoutingTable())
+	return g, nil
+}
+
+// StopGateway stops the local gateway and clears its routes from the config
+func (k *Environment) StopGateway() error {
+	if k.gateway == nil {
+		return nil
+	}
+	err := k.gateway.Close()
+	k.gateway = nil
+	if k.Config.Gateway != nil {
+		k.Config.Gateway.URL = ""
+		k.Config.Gateway.Routes = nil
+	}
+	return err
+}
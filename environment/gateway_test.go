@@ -0,0 +1,113 @@
+package environment_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatewayRoutes(t *testing.T) {
+	t.Parallel()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s?%s", r.URL.Path, r.URL.RawQuery)
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	backendPort, err := strconv.Atoi(backendURL.Port())
+	require.NoError(t, err)
+
+	charts := environment.Charts{
+		"chainlink": &environment.HelmChart{
+			ChartConnections: environment.ChartConnections{
+				"chainlink_node_0_node": &environment.ChartConnection{
+					App:         "chainlink_node",
+					Container:   "node",
+					RemotePorts: map[string]int{"access": 6688},
+					LocalPorts:  map[string]int{"access": backendPort},
+				},
+			},
+		},
+	}
+	g, err := environment.NewGateway(charts, 0)
+	require.NoError(t, err)
+	g.Start()
+	defer func() {
+		require.NoError(t, g.Close())
+	}()
+	require.Contains(t, g.Routes(), g.URL.String()+"/chainlink/chainlink_node/0/access")
+
+	resp, err := http.Get(g.URL.String() + "/chainlink/chainlink_node/0/access/v2/jobs?page=1")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "/v2/jobs?page=1", string(body))
+
+	resp, err = http.Get(g.URL.String() + "/chainlink/chainlink_node/1/access")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGatewaySharedPortNames(t *testing.T) {
+	t.Parallel()
+	backends := map[string]int{}
+	for _, container := range []string{"node", "proxy"} {
+		container := container
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "%s%s", container, r.URL.Path)
+		}))
+		defer backend.Close()
+		backendURL, err := url.Parse(backend.URL)
+		require.NoError(t, err)
+		backends[container], err = strconv.Atoi(backendURL.Port())
+		require.NoError(t, err)
+	}
+	charts := environment.Charts{
+		"chainlink": &environment.HelmChart{
+			ChartConnections: environment.ChartConnections{
+				"chainlink_node_0_node": &environment.ChartConnection{
+					App:         "chainlink_node",
+					Container:   "node",
+					RemotePorts: map[string]int{"http": 6688, "access": 6689},
+					LocalPorts:  map[string]int{"http": backends["node"], "access": backends["node"]},
+				},
+				"chainlink_node_0_proxy": &environment.ChartConnection{
+					App:         "chainlink_node",
+					Container:   "proxy",
+					RemotePorts: map[string]int{"http": 8080},
+					LocalPorts:  map[string]int{"http": backends["proxy"]},
+				},
+			},
+		},
+	}
+	g, err := environment.NewGateway(charts, 0)
+	require.NoError(t, err, "containers sharing a port name are told apart by the container")
+	g.Start()
+	defer func() {
+		require.NoError(t, g.Close())
+	}()
+	require.Len(t, g.Routes(), 3)
+	for route, expected := range map[string]string{
+		"/chainlink/chainlink_node/0/node/http/health":  "node/health",
+		"/chainlink/chainlink_node/0/proxy/http/health": "proxy/health",
+		"/chainlink/chainlink_node/0/access/health":     "node/health",
+	} {
+		resp, err := http.Get(g.URL.String() + route)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, expected, string(body), route)
+	}
+}
@@ -5,16 +5,13 @@ import (
 	"context"
 	"fmt"
 	"io/fs"
-	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
-	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/cavaliercoder/grab"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"helm.sh/helm/v3/pkg/action"
@@ -26,7 +23,6 @@ import (
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/remotecommand"
@@ -45,21 +41,48 @@ type Hook func(environment *Environment) error
 
 // HelmChart represents a single Helm chart to be installed into a cluster
 type HelmChart struct {
-	ReleaseName      string                 `yaml:"release_name,omitempty" json:"release_name,omitempty" envconfig:"release_name"`
-	Path             string                 `yaml:"path,omitempty" json:"path,omitempty" envconfig:"path"`
-	URL              string                 `yaml:"url,omitempty" json:"url,omitempty" envconfig:"url"`
-	Values           map[string]interface{} `yaml:"values,omitempty" json:"values,omitempty" envconfig:"values"`
-	Index            int                    `yaml:"index,omitempty" json:"index,omitempty" envconfig:"index"`
-	AutoConnect      bool                   `yaml:"auto_connect" json:"auto_connect" envconfig:"auto_connect"`
-	ChartConnections ChartConnections       `yaml:"chart_connections,omitempty" json:"chart_connections,omitempty" envconfig:"chart_connections"`
-	BeforeHook       Hook                   `yaml:"-" json:"-" envconfig:"-"`
-	AfterHook        Hook                   `yaml:"-" json:"-" envconfig:"-"`
+	ReleaseName         string                 `yaml:"release_name,omitempty" json:"release_name,omitempty" envconfig:"release_name"`
+	Path                string                 `yaml:"path,omitempty" json:"path,omitempty" envconfig:"path"`
+	URL                 string                 `yaml:"url,omitempty" json:"url,omitempty" envconfig:"url"`
+	Repository          string                 `yaml:"repository,omitempty" json:"repository,omitempty" envconfig:"repository"`
+	Chart               string                 `yaml:"chart,omitempty" json:"chart,omitempty" envconfig:"chart"`
+	Version             string                 `yaml:"version,omitempty" json:"version,omitempty" envconfig:"version"`
+	ResolvedVersion     string                 `yaml:"resolved_version,omitempty" json:"resolved_version,omitempty" envconfig:"resolved_version"`
+	RepositoryAuth      *RepositoryAuth        `yaml:"repository_auth,omitempty" json:"repository_auth,omitempty" envconfig:"repository_auth"`
+	GitRepository       string                 `yaml:"git_repository,omitempty" json:"git_repository,omitempty" envconfig:"git_repository"`
+	GitRef              string                 `yaml:"git_ref,omitempty" json:"git_ref,omitempty" envconfig:"git_ref"`
+	GitSubpath          string                 `yaml:"git_subpath,omitempty" json:"git_subpath,omitempty" envconfig:"git_subpath"`
+	ResolvedCommit      string                 `yaml:"resolved_commit,omitempty" json:"resolved_commit,omitempty" envconfig:"resolved_commit"`
+	SHA256              string                 `yaml:"sha256,omitempty" json:"sha256,omitempty" envconfig:"sha256"`
+	Verify              bool                   `yaml:"verify,omitempty" json:"verify,omitempty" envconfig:"verify"`
+	Keyring             string                 `yaml:"keyring,omitempty" json:"keyring,omitempty" envconfig:"keyring"`
+	VerifiedSigner      string                 `yaml:"verified_signer,omitempty" json:"verified_signer,omitempty" envconfig:"verified_signer"`
+	Values              map[string]interface{} `yaml:"values,omitempty" json:"values,omitempty" envconfig:"values"`
+	ValuesFiles         []string               `yaml:"values_files,omitempty" json:"values_files,omitempty" envconfig:"values_files"`
+	Set                 []string               `yaml:"set,omitempty" json:"set,omitempty" envconfig:"set"`
+	UnknownValues       string                 `yaml:"unknown_values,omitempty" json:"unknown_values,omitempty" envconfig:"unknown_values"`
+	RedactedValues      []string               `yaml:"redacted_values,omitempty" json:"redacted_values,omitempty" envconfig:"redacted_values"`
+	Index               int                    `yaml:"index,omitempty" json:"index,omitempty" envconfig:"index"`
+	AutoConnect         bool                   `yaml:"auto_connect" json:"auto_connect" envconfig:"auto_connect"`
+	ConnectionStrategy  string                 `yaml:"connection_strategy,omitempty" json:"connection_strategy,omitempty" envconfig:"connection_strategy"`
+	ChartConnections    ChartConnections       `yaml:"chart_connections,omitempty" json:"chart_connections,omitempty" envconfig:"chart_connections"`
+	ServiceConnections  ServiceConnections     `yaml:"service_connections,omitempty" json:"service_connections,omitempty" envconfig:"service_connections"`
+	Credentials         []*Credential          `yaml:"credentials,omitempty" json:"credentials,omitempty" envconfig:"credentials"`
+	InitContainers      bool                   `yaml:"init_containers,omitempty" json:"init_containers,omitempty" envconfig:"init_containers"`
+	EphemeralContainers bool                   `yaml:"ephemeral_containers,omitempty" json:"ephemeral_containers,omitempty" envconfig:"ephemeral_containers"`
+	InstanceEnumeration string                 `yaml:"instance_enumeration,omitempty" json:"instance_enumeration,omitempty" envconfig:"instance_enumeration"`
+	RemoteURLMode       string                 `yaml:"remote_url_mode,omitempty" json:"remote_url_mode,omitempty" envconfig:"remote_url_mode"`
+	BeforeHook          Hook                   `yaml:"-" json:"-" envconfig:"-"`
+	AfterHook           Hook                   `yaml:"-" json:"-" envconfig:"-"`
 
 	// Internal properties used for deployment
 	namespaceName string
 	env           *Environment
 	actionConfig  *action.Configuration
 	podsList      *v1.PodList
+	podWorkloads  map[string]Workload
+	secretPaths   []string
+	templatePaths []string
 }
 
 // Init sets up the connection to helm for the chart to be managed
@@ -67,38 +90,38 @@ func (hc *HelmChart) Init(env *Environment) error {
 	if hc.ChartConnections == nil {
 		hc.ChartConnections = ChartConnections{}
 	}
+	if hc.ServiceConnections == nil {
+		hc.ServiceConnections = ServiceConnections{}
+	}
+	hc.setDefaultCredentials()
 	hc.env = env
 	hc.namespaceName = env.Namespace
+	hc.ChartConnections.setRemoteURLMode(hc.remoteURLMode())
 	return hc.init()
 }
 
-// Connect connects to all exposed containerPorts, forwards them to local
+// Connect exposes all containerPorts and services with the connection strategy of the chart, port forwarding them
+// to local by default
 func (hc *HelmChart) Connect() error {
-	var rangeErr error
-	hc.ChartConnections.Range(func(key string, chartConnection *ChartConnection) bool {
-		rules, err := hc.makePortRules(chartConnection)
-		if err != nil {
-			rangeErr = err
-			return false
-		}
-		if err := hc.connectPod(chartConnection, rules); err != nil {
-			rangeErr = err
-			return false
-		}
+	strategy, err := hc.connectionStrategy()
+	if err != nil {
+		return err
+	}
+	hc.ChartConnections.Range(func(_ string, chartConnection *ChartConnection) bool {
+		chartConnection.clearLocal()
 		return true
 	})
-	if rangeErr != nil {
-		return rangeErr
-	}
-	return nil
+	hc.ServiceConnections.Range(func(_ string, serviceConnection *ServiceConnection) bool {
+		serviceConnection.clearLocal()
+		return true
+	})
+	return strategy.Connect(hc)
 }
 
 // Deploy deploys a chart and update config settings
 func (hc *HelmChart) Deploy() error {
-	if len(hc.URL) > 0 {
-		if err := hc.downloadChart(); err != nil {
-			return err
-		}
+	if err := hc.FetchChart(); err != nil {
+		return err
 	}
 	if hc.BeforeHook != nil {
 		if err := hc.BeforeHook(hc.env); err != nil {
@@ -117,6 +140,9 @@ func (hc *HelmChart) Deploy() error {
 	if err := hc.updateChartSettings(); err != nil {
 		return err
 	}
+	if err := hc.updateServiceSettings(); err != nil {
+		return err
+	}
 	if hc.AutoConnect {
 		if err := hc.Connect(); err != nil {
 			return err
@@ -144,6 +170,9 @@ func (hc *HelmChart) Uninstall() error {
 
 // Upgrade an already deployed Helm chart with new values
 func (hc *HelmChart) Upgrade() error {
+	if err := hc.FetchChart(); err != nil {
+		return err
+	}
 	helmChart, err := hc.loadChart()
 	if err != nil {
 		return err
@@ -155,7 +184,7 @@ func (hc *HelmChart) Upgrade() error {
 	// blocks until all podsPortsInfo are healthy
 	upgrader.Wait = true
 
-	if _, err := upgrader.Run(hc.ReleaseName, helmChart, hc.Values); err != nil {
+	if _, err := upgrader.Run(hc.ReleaseName, helmChart, helmChart.Values); err != nil {
 		return err
 	}
 	if err := hc.enumerateApps(); err != nil {
@@ -164,7 +193,10 @@ func (hc *HelmChart) Upgrade() error {
 	if err := hc.fetchPods(); err != nil {
 		return err
 	}
-	return hc.updateChartSettings()
+	if err := hc.updateChartSettings(); err != nil {
+		return err
+	}
+	return hc.updateServiceSettings()
 }
 
 // CopyToPod copies src to a particular container. Destination should be in the form of a proper K8s destination path
@@ -323,13 +355,39 @@ func (hc *HelmChart) loadEmbeddedChartFiles() ([]*loader.BufferedFile, error) {
 }
 
 func (hc *HelmChart) loadChart() (*chart.Chart, error) {
-	var err error
-	var loadedChart *chart.Chart
+	loadedChart, err := hc.readChart()
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("Path", hc.Path).
+		Str("Release", hc.ReleaseName).
+		Str("Namespace", hc.namespaceName).
+		Interface("Overrides", hc.RedactValues(hc.Values)).
+		Strs("ValuesFiles", hc.ValuesFiles).
+		Strs("Set", redactSet(hc.Set, hc.redactedPaths())).
+		Msg("Installing Helm chart")
+	values, err := hc.chartValues(loadedChart.Name())
+	if err != nil {
+		return nil, err
+	}
+	if err := hc.validateValues(loadedChart, values, hc.unknownValues("")); err != nil {
+		return nil, err
+	}
+	loadedChart.Values, err = chartutil.CoalesceValues(loadedChart, values)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Interface("Values", hc.RedactValues(loadedChart.Values)).Msg("Merged chart values")
+	return loadedChart, nil
+}
+
+// readChart loads the chart from its path, or from the embedded charts if it isn't on the host
+func (hc *HelmChart) readChart() (*chart.Chart, error) {
 	if hc.Path == "" {
 		hc.Path = filepath.Join("charts", hc.ReleaseName)
 	}
 	log.Info().Str("Path", hc.Path).Msg("Searching chart")
-	loadedChart, err = loader.Load(hc.Path)
+	loadedChart, err := loader.Load(hc.Path)
 	source := "host"
 	if err != nil {
 		source = "embedded"
@@ -342,17 +400,7 @@ func (hc *HelmChart) loadChart() (*chart.Chart, error) {
 			return nil, errors.Wrapf(err, "faild to load embedded char files: %s", hc.Path)
 		}
 	}
-	log.Info().Str("Path", hc.Path).
-		Str("Source", source).
-		Str("Release", hc.ReleaseName).
-		Str("Namespace", hc.namespaceName).
-		Interface("Overrides", hc.Values).
-		Msg("Installing Helm chart")
-	loadedChart.Values, err = chartutil.CoalesceValues(loadedChart, hc.Values)
-	if err != nil {
-		return nil, err
-	}
-	log.Info().Interface("Values", loadedChart.Values).Msg("Merged chart values")
+	log.Debug().Str("Path", hc.Path).Str("Source", source).Msg("Chart loaded")
 	return loadedChart, nil
 }
 
@@ -381,100 +429,52 @@ func (hc *HelmChart) deployChart() error {
 	return nil
 }
 
-func (hc *HelmChart) downloadChart() error {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return err
-	}
-	downloadDir := filepath.Join(homeDir, ".helmenv")
-	if _, err := os.Stat(downloadDir); os.IsNotExist(err) {
-		if err := os.Mkdir(downloadDir, 0755); err != nil {
-			return fmt.Errorf("failed to create helmenv directory: %v", err)
+func (hc *HelmChart) updateChartSettings() error {
+	apps := map[string][]v1.Pod{}
+	for _, p := range hc.podsList.Items {
+		app, ok := p.Labels[AppEnumerationLabelKey]
+		if !ok {
+			app = hc.podWorkloads[p.Name].Name
+			log.Debug().Str("Pod", p.Name).Str("App", app).Msg("App label not found, using the workload name")
 		}
+		apps[app] = append(apps[app], p)
 	}
-	chartURL, err := url.Parse(hc.URL)
-	if err != nil {
-		log.Error().Err(err).Msg("Invalid URL given for the Helm chart")
-	}
-
-	fileName := path.Base(chartURL.Path)
-	filePath := filepath.Join(downloadDir, fileName)
-	if _, err := os.Stat(filePath); err == nil {
-		log.Debug().Str("URL", hc.URL).Msg("Chart already downloaded")
-		hc.Path = filePath
-		return nil
-	}
-
-	log.Info().Str("URL", hc.URL).Msg("Downloading Helm chart from repository")
-	resp, err := grab.Get(downloadDir, hc.URL)
-	if err != nil {
-		return fmt.Errorf("failed to download Helm chart: %v", err)
-	}
-	hc.Path = resp.Filename
-	return nil
-}
-
-func (hc *HelmChart) fetchPods() error {
-	var err error
-	k8sPods := hc.env.k8sClient.CoreV1().Pods(hc.namespaceName)
-	hc.podsList, err = k8sPods.List(context.Background(), metaV1.ListOptions{
-		LabelSelector: fmt.Sprintf("release=%s", hc.ReleaseName),
-	})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (hc *HelmChart) addInstanceLabel(app string) error {
-	k8sPods := hc.env.k8sClient.CoreV1().Pods(hc.namespaceName)
-	l, err := k8sPods.List(context.Background(), metaV1.ListOptions{LabelSelector: fmt.Sprintf("app=%s", app)})
-	if err != nil {
-		return err
-	}
-	// Ensures that instance label applies in order to port mapping
-	sort.Slice(l.Items, func(i, j int) bool {
-		return l.Items[i].Status.PodIP < l.Items[j].Status.PodIP
-	})
-	for i, pod := range l.Items {
-		labelPatch := fmt.Sprintf(`[{"op":"add","path":"/metadata/labels/%s","value":"%d" }]`, "instance", i)
-		_, err := k8sPods.Patch(context.Background(), pod.GetName(), types.JSONPatchType, []byte(labelPatch), metaV1.PatchOptions{})
+	for _, app := range sortedKeys(apps) {
+		// pods keep the instance they are labelled with, the ones that aren't labelled are enumerated like labelled pods
+		instances, err := EnumeratePods(apps[app], hc.instanceEnumeration())
 		if err != nil {
-			return errors.Wrapf(err, "failed to update labels %s for pod %s", labelPatch, pod.Name)
+			return err
 		}
-	}
-	return nil
-}
-
-func (hc *HelmChart) updateChartSettings() error {
-	for _, p := range hc.podsList.Items {
-		for _, c := range p.Spec.Containers {
-			app, ok := p.Labels[AppEnumerationLabelKey]
-			if !ok {
-				log.Warn().Str("Container", c.Name).Msg("App label not found")
-			}
-			instance := p.Labels[InstanceEnumerationLabelKey]
-			if !ok {
-				log.Warn().Str("Container", c.Name).Msg("Instance label not found")
-			}
-			log.Info().
-				Str("Container", c.Name).
-				Interface("PodPorts", c.Ports).
-				Msg("Container info")
-			pm := map[string]int{}
-			for _, port := range c.Ports {
-				pm[port.Name] = int(port.ContainerPort)
+		for _, p := range apps[app] {
+			instance := strconv.Itoa(instances[p.Name])
+			if _, ok := p.Labels[InstanceEnumerationLabelKey]; !ok {
+				log.Debug().Str("Pod", p.Name).Str("Instance", instance).Msg("Instance label not found, enumerated the pod")
 			}
-			if err := hc.ChartConnections.Store(app, instance, c.Name, &ChartConnection{
-				PodName:     p.Name,
-				PodIP:       p.Status.PodIP,
-				RemotePorts: pm,
-				LocalPorts:  make(map[string]int),
-			}); err != nil {
-				return err
+			workload := hc.podWorkloads[p.Name]
+			for _, c := range hc.podContainers(&p) {
+				log.Info().
+					Str("Container", c.name).
+					Str("Workload", workload.String()).
+					Interface("PodPorts", c.ports).
+					Msg("Container info")
+				pm := map[string]int{}
+				for _, port := range c.ports {
+					pm[port.Name] = int(port.ContainerPort)
+				}
+				if err := hc.ChartConnections.Store(app, instance, c.name, &ChartConnection{
+					Workload:    &Workload{Kind: workload.Kind, Name: workload.Name},
+					PodName:     p.Name,
+					PodIP:       p.Status.PodIP,
+					PodDNS:      podDNSName(&p),
+					RemotePorts: pm,
+					LocalPorts:  make(map[string]int),
+				}); err != nil {
+					return err
+				}
 			}
 		}
 	}
+	hc.ChartConnections.setRemoteURLMode(hc.remoteURLMode())
 	return nil
 }
 
@@ -504,6 +504,7 @@ func (hc *HelmChart) uniqueAppLabels(selector string) ([]string, error) {
 	for _, p := range podList.Items {
 		appLabel := p.Labels[AppEnumerationLabelKey]
 		if _, ok := isUnique[appLabel]; !ok {
+			isUnique[appLabel] = true
 			uniqueLabels = append(uniqueLabels, appLabel)
 		}
 	}
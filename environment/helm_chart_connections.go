@@ -1,9 +1,13 @@
 package environment
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 )
@@ -22,12 +26,128 @@ const (
 	HTTPS
 )
 
+func (p Protocol) scheme() (string, error) {
+	switch p {
+	case WS:
+		return "ws", nil
+	case WSS:
+		return "wss", nil
+	case HTTP:
+		return "http", nil
+	case HTTPS:
+		return "https", nil
+	default:
+		return "", errors.New("no such protocol")
+	}
+}
+
 // ChartConnection info about connected pod ports
 type ChartConnection struct {
-	PodName     string         `yaml:"pod_name,omitempty" json:"pod_name" envconfig:"pod_name"`
-	PodIP       string         `yaml:"pod_ip,omitempty" json:"pod_ip" envconfig:"pod_ip"`
-	RemotePorts map[string]int `yaml:"remote_ports,omitempty" json:"remote_ports" envconfig:"remote_ports"`
-	LocalPorts  map[string]int `yaml:"local_ports,omitempty" json:"local_ports" envconfig:"local_ports"`
+	App          string            `yaml:"app,omitempty" json:"app" envconfig:"app"`
+	Instance     int               `yaml:"instance" json:"instance" envconfig:"instance"`
+	Container    string            `yaml:"container,omitempty" json:"container" envconfig:"container"`
+	Workload     *Workload         `yaml:"workload,omitempty" json:"workload,omitempty" envconfig:"workload"`
+	PodName      string            `yaml:"pod_name,omitempty" json:"pod_name" envconfig:"pod_name"`
+	PodIP        string            `yaml:"pod_ip,omitempty" json:"pod_ip" envconfig:"pod_ip"`
+	PodDNS       string            `yaml:"pod_dns,omitempty" json:"pod_dns,omitempty" envconfig:"pod_dns"`
+	ServiceHosts map[string]string `yaml:"service_hosts,omitempty" json:"service_hosts,omitempty" envconfig:"service_hosts"`
+	ServicePorts map[string]int    `yaml:"service_ports,omitempty" json:"service_ports,omitempty" envconfig:"service_ports"`
+	RemotePorts  map[string]int    `yaml:"remote_ports,omitempty" json:"remote_ports" envconfig:"remote_ports"`
+	LocalPorts   map[string]int    `yaml:"local_ports,omitempty" json:"local_ports" envconfig:"local_ports"`
+	LocalHosts   map[string]string `yaml:"local_hosts,omitempty" json:"local_hosts,omitempty" envconfig:"local_hosts"`
+	LocalPaths   map[string]string `yaml:"local_paths,omitempty" json:"local_paths,omitempty" envconfig:"local_paths"`
+
+	// mu guards the pod and local details, recreated pods replace them while connected
+	mu            sync.RWMutex
+	forwarder     *podForwarder
+	remoteURLMode string
+}
+
+// chartConnectionFields has the fields of a chart connection without its marshalling methods
+type chartConnectionFields ChartConnection
+
+// snapshot copies the connection under the lock refreshing recreated pods changes it with, the copy can be read while
+// the connection is changed
+func (c *ChartConnection) snapshot() *ChartConnection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return &ChartConnection{
+		App:           c.App,
+		Instance:      c.Instance,
+		Container:     c.Container,
+		Workload:      c.Workload,
+		PodName:       c.PodName,
+		PodIP:         c.PodIP,
+		PodDNS:        c.PodDNS,
+		ServiceHosts:  c.ServiceHosts,
+		ServicePorts:  c.ServicePorts,
+		RemotePorts:   c.RemotePorts,
+		LocalPorts:    copyMap(c.LocalPorts),
+		LocalHosts:    copyMap(c.LocalHosts),
+		LocalPaths:    copyMap(c.LocalPaths),
+		forwarder:     c.forwarder,
+		remoteURLMode: c.remoteURLMode,
+	}
+}
+
+// MarshalYAML marshals a snapshot of the connection, a recreated pod may change it meanwhile
+func (c *ChartConnection) MarshalYAML() (interface{}, error) {
+	return (*chartConnectionFields)(c.snapshot()), nil
+}
+
+// MarshalJSON marshals a snapshot of the connection, a recreated pod may change it meanwhile
+func (c *ChartConnection) MarshalJSON() ([]byte, error) {
+	return json.Marshal((*chartConnectionFields)(c.snapshot()))
+}
+
+// clearLocal removes all the local connection details set by connecting
+func (c *ChartConnection) clearLocal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.LocalPorts = nil
+	c.LocalHosts = nil
+	c.LocalPaths = nil
+}
+
+// setLocal records an externally reachable address of a port, an empty host means the port is forwarded to localhost
+func (c *ChartConnection) setLocal(portName string, host string, port int, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.LocalPorts == nil {
+		c.LocalPorts = map[string]int{}
+	}
+	c.LocalPorts[portName] = port
+	setLocalAddress(&c.LocalHosts, &c.LocalPaths, portName, host, path)
+}
+
+// localize points a URL built for localhost at the host and path the port is exposed on
+func (c *ChartConnection) localize(u *url.URL, portName string) {
+	local := c.snapshot()
+	localizeURL(u, local.LocalHosts, local.LocalPaths, portName)
+}
+
+func setLocalAddress(hosts *map[string]string, paths *map[string]string, portName string, host string, path string) {
+	if host != "" {
+		if *hosts == nil {
+			*hosts = map[string]string{}
+		}
+		(*hosts)[portName] = host
+	}
+	if path != "" {
+		if *paths == nil {
+			*paths = map[string]string{}
+		}
+		(*paths)[portName] = path
+	}
+}
+
+func localizeURL(u *url.URL, hosts map[string]string, paths map[string]string, portName string) {
+	if host, ok := hosts[portName]; ok {
+		u.Host = strings.Replace(u.Host, "localhost", host, 1)
+	}
+	if path, ok := paths[portName]; ok {
+		u.Path = path
+	}
 }
 
 // ChartConnections represents a group of pods and their connection info deployed within the same chart
@@ -42,9 +162,23 @@ func (cc ChartConnections) Range(f func(key string, chartConnection *ChartConnec
 	}
 }
 
+// setRemoteURLMode records the remote URL mode of the chart on its connections, for URLs built without the chart
+func (cc ChartConnections) setRemoteURLMode(mode string) {
+	for _, c := range cc {
+		c.remoteURLMode = mode
+	}
+}
+
 // Store emulates the default Store function within the sync.Map to use the common map key and value types and
 // return an error if the key is a duplicate
 func (cc ChartConnections) Store(app, instance, name string, chartConnection *ChartConnection) error {
+	instanceNumber, err := strconv.Atoi(instance)
+	if err != nil {
+		return errors.Wrapf(err, "instance of app %s must be a number", app)
+	}
+	chartConnection.App = app
+	chartConnection.Instance = instanceNumber
+	chartConnection.Container = name
 	mapKey := cc.mapKey(app, instance, name)
 	cc[mapKey] = chartConnection
 	return nil
@@ -93,8 +227,12 @@ func (cc *ChartConnections) LoadByPortName(portName string) ([]*ChartConnection,
 
 	// Ensures that when calling either for local or remote ports, the pods are returned in the same order. This enables
 	// matching of Local and Remote URLs.
+	podIPs := make(map[*ChartConnection]string, len(connections))
+	for _, connection := range connections {
+		podIPs[connection] = connection.snapshot().PodIP
+	}
 	sort.Slice(connections, func(i, j int) bool {
-		return connections[i].PodIP < connections[j].PodIP
+		return podIPs[connections[i]] < podIPs[connections[j]]
 	})
 
 	return connections, nil
@@ -102,18 +240,11 @@ func (cc *ChartConnections) LoadByPortName(portName string) ([]*ChartConnection,
 
 // RemoteURLsByPort returns parsed URLs of a remote service based on the port name
 func (cc *ChartConnections) RemoteURLsByPort(portName string, protocol Protocol) ([]*url.URL, error) {
-	switch protocol {
-	case WS:
-		return cc.RemoteURLs("ws://%s:%d", portName)
-	case WSS:
-		return cc.RemoteURLs("wss://%s:%d", portName)
-	case HTTP:
-		return cc.RemoteURLs("http://%s:%d", portName)
-	case HTTPS:
-		return cc.RemoteURLs("https://%s:%d", portName)
-	default:
-		return nil, errors.New("no such protocol")
+	scheme, err := protocol.scheme()
+	if err != nil {
+		return nil, err
 	}
+	return cc.RemoteURLs(scheme+"://%s:%d", portName)
 }
 
 // RemoteURLByPort returns a parsed URL of a remote service based on the port name
@@ -127,18 +258,11 @@ func (cc *ChartConnections) RemoteURLByPort(portName string, protocol Protocol)
 
 // LocalURLsByPort returns parsed URLs of a local port-forwarded service based on the port name
 func (cc *ChartConnections) LocalURLsByPort(portName string, protocol Protocol) ([]*url.URL, error) {
-	switch protocol {
-	case WS:
-		return cc.LocalURLs("ws://localhost:%d", portName)
-	case WSS:
-		return cc.LocalURLs("wss://localhost:%d", portName)
-	case HTTP:
-		return cc.LocalURLs("http://localhost:%d", portName)
-	case HTTPS:
-		return cc.LocalURLs("https://localhost:%d", portName)
-	default:
-		return nil, errors.New("no such protocol")
+	scheme, err := protocol.scheme()
+	if err != nil {
+		return nil, err
 	}
+	return cc.LocalURLs(scheme+"://localhost:%d", portName)
 }
 
 // LocalURLByPort returns a parsed URL of a local port-forwarded service based on the port name
@@ -150,17 +274,23 @@ func (cc *ChartConnections) LocalURLByPort(portName string, protocol Protocol) (
 	}
 }
 
-// RemoteURLs scans all the connections returns remote URLs based on a port number of a service and a string directive
+// RemoteURLs scans all the connections returns remote URLs based on a port number of a service and a string directive,
+// the host and port are the ones of the remote URL mode of the chart
 func (cc *ChartConnections) RemoteURLs(stringDirective string, portName string) ([]*url.URL, error) {
 	var urls []*url.URL
 	connections, err := cc.LoadByPortName(portName)
 	if err != nil {
 		return urls, err
 	}
-	for _, connection := range connections {
-		for remotePortName, remotePort := range connection.RemotePorts {
+	for _, c := range connections {
+		connection := c.snapshot()
+		for remotePortName := range connection.RemotePorts {
 			if remotePortName == portName {
-				parsedURL, err := url.Parse(fmt.Sprintf(stringDirective, connection.PodIP, remotePort))
+				host, port, err := connection.RemoteAddress(remotePortName, connection.remoteURLMode)
+				if err != nil {
+					return nil, err
+				}
+				parsedURL, err := url.Parse(fmt.Sprintf(stringDirective, host, port))
 				if err != nil {
 					return nil, err
 				}
@@ -171,14 +301,16 @@ func (cc *ChartConnections) RemoteURLs(stringDirective string, portName string)
 	return urls, nil
 }
 
-// LocalURLs scans all the connections returns remote URLs based on a port number of a service and a string directive
+// LocalURLs scans all the connections returns local URLs based on a port number of a service and a string directive,
+// connections exposed outside of the cluster get the localhost replaced by the host they are exposed on
 func (cc *ChartConnections) LocalURLs(stringDirective string, portName string) ([]*url.URL, error) {
 	var urls []*url.URL
 	connections, err := cc.LoadByPortName(portName)
 	if err != nil {
 		return nil, err
 	}
-	for _, connection := range connections {
+	for _, c := range connections {
+		connection := c.snapshot()
 		for remotePortName := range connection.RemotePorts {
 			if remotePortName == portName {
 				localPort, ok := connection.LocalPorts[remotePortName]
@@ -189,6 +321,7 @@ func (cc *ChartConnections) LocalURLs(stringDirective string, portName string) (
 				if err != nil {
 					return nil, err
 				}
+				connection.localize(parsedURL, remotePortName)
 				urls = append(urls, parsedURL)
 			}
 		}
@@ -199,3 +332,17 @@ func (cc *ChartConnections) LocalURLs(stringDirective string, portName string) (
 func (cc *ChartConnections) mapKey(app, instance, name string) string {
 	return fmt.Sprintf("%s_%s_%s", app, instance, name)
 }
+
+// parseMapKey splits a map key back into app, instance and container name, container names and instances can't
+// contain underscores so the key is split from the right
+func parseMapKey(key string) (string, string, string, bool) {
+	nameIdx := strings.LastIndex(key, "_")
+	if nameIdx < 0 {
+		return "", "", "", false
+	}
+	instanceIdx := strings.LastIndex(key[:nameIdx], "_")
+	if instanceIdx < 0 {
+		return "", "", "", false
+	}
+	return key[:instanceIdx], key[instanceIdx+1 : nameIdx], key[nameIdx+1:], true
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/smartcontractkit/helmenv/environment"
 	"github.com/smartcontractkit/helmenv/tools"
 	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 // Ensures that connections are collected in consistent order, ensuring that RemoteURLs and LocalURLs match each other
@@ -61,3 +62,40 @@ func TestURLsByPort(t *testing.T) {
 	}
 
 }
+
+func TestRemoteURLsByPortRemoteURLMode(t *testing.T) {
+	t.Parallel()
+
+	e := environment.NewEnvironmentWithClient(&environment.Config{
+		Namespace:     "env",
+		RemoteURLMode: environment.ServiceDNSRemoteURLs,
+	}, fake.NewSimpleClientset())
+	chart := &environment.HelmChart{
+		ReleaseName: "chainlink",
+		Index:       1,
+		ChartConnections: environment.ChartConnections{
+			"chainlink-node_0_node": {
+				App:          "chainlink-node",
+				Container:    "node",
+				PodName:      "chainlink-node-0",
+				PodIP:        "10.0.0.1",
+				ServiceHosts: map[string]string{"access": "chainlink-node.env.svc.cluster.local"},
+				ServicePorts: map[string]int{"access": 80},
+				RemotePorts:  map[string]int{"access": 6688, "p2p": 6690},
+			},
+		},
+	}
+	require.NoError(t, e.AddChart(chart))
+	u, err := chart.ChartConnections.RemoteURLByPort("access", environment.HTTP)
+	require.NoError(t, err)
+	require.Equal(t, "http://chainlink-node.env.svc.cluster.local:80", u.String())
+	_, err = chart.ChartConnections.RemoteURLByPort("p2p", environment.HTTP)
+	require.EqualError(t, err, "port p2p of pod chainlink-node-0 isn't exposed by any service")
+
+	u, err = chart.ChartConnections.Query().Port("access").RemoteURL(environment.HTTP)
+	require.NoError(t, err)
+	require.Equal(t, "http://chainlink-node.env.svc.cluster.local:80", u.String(), "queries of a chart use its mode too")
+	u, err = chart.ChartConnections.Query().Port("access").RemoteURLMode(environment.PodIPRemoteURLs).RemoteURL(environment.HTTP)
+	require.NoError(t, err)
+	require.Equal(t, "http://10.0.0.1:6688", u.String())
+}
@@ -0,0 +1,415 @@
+package environment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// HelmReleaseNameAnnotation annotation Helm sets on every resource it creates for a release
+	HelmReleaseNameAnnotation = "meta.helm.sh/release-name"
+	// ServiceFailoverInterval how long to wait before retrying to forward to another endpoint of a service
+	ServiceFailoverInterval = 2 * time.Second
+)
+
+// ServiceConnection info about a service and the endpoint pod its ports are currently forwarded to
+type ServiceConnection struct {
+	ServiceName string            `yaml:"service_name,omitempty" json:"service_name" envconfig:"service_name"`
+	ClusterIP   string            `yaml:"cluster_ip,omitempty" json:"cluster_ip" envconfig:"cluster_ip"`
+	PodName     string            `yaml:"pod_name,omitempty" json:"pod_name" envconfig:"pod_name"`
+	RemotePorts map[string]int    `yaml:"remote_ports,omitempty" json:"remote_ports" envconfig:"remote_ports"`
+	LocalPorts  map[string]int    `yaml:"local_ports,omitempty" json:"local_ports" envconfig:"local_ports"`
+	LocalHosts  map[string]string `yaml:"local_hosts,omitempty" json:"local_hosts,omitempty" envconfig:"local_hosts"`
+	LocalPaths  map[string]string `yaml:"local_paths,omitempty" json:"local_paths,omitempty" envconfig:"local_paths"`
+
+	// mu guards the pod and local details, failover replaces them while connected
+	mu sync.RWMutex
+}
+
+// serviceConnectionFields has the fields of a service connection without its marshalling methods
+type serviceConnectionFields ServiceConnection
+
+// snapshot copies the connection under the lock failover changes it with
+func (s *ServiceConnection) snapshot() *serviceConnectionFields {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &serviceConnectionFields{
+		ServiceName: s.ServiceName,
+		ClusterIP:   s.ClusterIP,
+		PodName:     s.PodName,
+		RemotePorts: s.RemotePorts,
+		LocalPorts:  copyMap(s.LocalPorts),
+		LocalHosts:  copyMap(s.LocalHosts),
+		LocalPaths:  copyMap(s.LocalPaths),
+	}
+}
+
+// ForwardedPod returns the endpoint pod the service ports are currently forwarded to
+func (s *ServiceConnection) ForwardedPod() string {
+	return s.snapshot().PodName
+}
+
+// MarshalYAML marshals a snapshot of the connection, failover may change it meanwhile
+func (s *ServiceConnection) MarshalYAML() (interface{}, error) {
+	return s.snapshot(), nil
+}
+
+// MarshalJSON marshals a snapshot of the connection, failover may change it meanwhile
+func (s *ServiceConnection) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.snapshot())
+}
+
+func copyMap[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]V, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// clearLocal removes all the local connection details set by connecting
+func (s *ServiceConnection) clearLocal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.PodName = ""
+	s.LocalPorts = nil
+	s.LocalHosts = nil
+	s.LocalPaths = nil
+}
+
+// setLocal records an externally reachable address of a port, an empty host means the port is forwarded to localhost
+func (s *ServiceConnection) setLocal(portName string, host string, port int, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.LocalPorts == nil {
+		s.LocalPorts = map[string]int{}
+	}
+	s.LocalPorts[portName] = port
+	setLocalAddress(&s.LocalHosts, &s.LocalPaths, portName, host, path)
+}
+
+// ServiceConnections represents the services deployed within the same chart, keyed by the service name
+type ServiceConnections map[string]*ServiceConnection
+
+// Range emulates the default range function in the sync.Map, without the need to cast the key & value
+func (sc ServiceConnections) Range(f func(key string, serviceConnection *ServiceConnection) bool) {
+	for k, v := range sc {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// Load returns a service connection by the service name
+func (sc ServiceConnections) Load(serviceName string) (*ServiceConnection, error) {
+	if _, ok := sc[serviceName]; !ok {
+		return nil, fmt.Errorf("service connection by the name of '%s' doesn't exist", serviceName)
+	}
+	return sc[serviceName], nil
+}
+
+// LocalURL returns a parsed URL of a local port-forwarded service port
+func (s *ServiceConnection) LocalURL(portName string, protocol Protocol) (*url.URL, error) {
+	builder, err := protocol.builder()
+	if err != nil {
+		return nil, err
+	}
+	return s.LocalURLWith(portName, builder)
+}
+
+// LocalURLWith returns a URL of a local port-forwarded service port built by the URL builder
+func (s *ServiceConnection) LocalURLWith(portName string, builder *URLBuilder) (*url.URL, error) {
+	local := s.snapshot()
+	localPort, ok := local.LocalPorts[portName]
+	if !ok {
+		return nil, fmt.Errorf("local port %s for service %s doesn't exist, must not be connected", portName, s.ServiceName)
+	}
+	return builder.buildLocal(local.LocalHosts, local.LocalPaths, portName, localPort)
+}
+
+// RemoteURL returns a parsed URL of a service port, resolvable from within the environment namespace
+func (s *ServiceConnection) RemoteURL(portName string, protocol Protocol) (*url.URL, error) {
+	builder, err := protocol.builder()
+	if err != nil {
+		return nil, err
+	}
+	return s.RemoteURLWith(portName, builder)
+}
+
+// RemoteURLWith returns a URL of a service port built by the URL builder, resolvable from within the environment
+// namespace
+func (s *ServiceConnection) RemoteURLWith(portName string, builder *URLBuilder) (*url.URL, error) {
+	remotePort, ok := s.RemotePorts[portName]
+	if !ok {
+		return nil, fmt.Errorf("port %s for service %s doesn't exist", portName, s.ServiceName)
+	}
+	return builder.Build(s.ServiceName, remotePort)
+}
+
+// ConnectService forwards all ports of a single service in the chart to local ports
+func (hc *HelmChart) ConnectService(serviceName string) error {
+	serviceConnection, err := hc.ServiceConnections.Load(serviceName)
+	if err != nil {
+		return err
+	}
+	return hc.env.runServiceForwarder(serviceConnection, time.Second*30)
+}
+
+// connectServices forwards all services of the chart to local ports
+func (hc *HelmChart) connectServices() error {
+	var rangeErr error
+	hc.ServiceConnections.Range(func(_ string, serviceConnection *ServiceConnection) bool {
+		if len(serviceConnection.RemotePorts) == 0 {
+			return true
+		}
+		if err := hc.env.runServiceForwarder(serviceConnection, time.Second*30); err != nil {
+			rangeErr = err
+			return false
+		}
+		return true
+	})
+	return rangeErr
+}
+
+// updateServiceSettings discovers all services created by the release and records their ports, and the services
+// exposing the ports of every pod connection
+func (hc *HelmChart) updateServiceSettings() error {
+	if hc.ServiceConnections == nil {
+		hc.ServiceConnections = ServiceConnections{}
+	}
+	var services []*v1.Service
+	err := hc.rangeReleaseServices(func(s *v1.Service) error {
+		services = append(services, s)
+		pm := map[string]int{}
+		for _, port := range s.Spec.Ports {
+			pm[servicePortName(port)] = int(port.Port)
+		}
+		log.Info().
+			Str("Service", s.Name).
+			Interface("ServicePorts", pm).
+			Msg("Service info")
+		hc.ServiceConnections[s.Name] = &ServiceConnection{
+			ServiceName: s.Name,
+			ClusterIP:   s.Spec.ClusterIP,
+			RemotePorts: pm,
+			LocalPorts:  make(map[string]int),
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return hc.recordServiceHosts(services)
+}
+
+// servicePortName returns the name of a service port, unnamed ports are only allowed for single port services
+// and are named after their port number
+func servicePortName(port v1.ServicePort) string {
+	if port.Name == "" {
+		return strconv.Itoa(int(port.Port))
+	}
+	return port.Name
+}
+
+// serviceForwarder keeps the ports of a service forwarded, failing over to another ready endpoint pod
+// whenever the one currently forwarded goes away
+type serviceForwarder struct {
+	env               *Environment
+	serviceConnection *ServiceConnection
+
+	mu        sync.Mutex
+	forwarder *podForwarder
+	closed    bool
+	done      chan struct{}
+}
+
+// Close stops forwarding the service ports
+func (sf *serviceForwarder) Close() {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.closed {
+		return
+	}
+	sf.closed = true
+	close(sf.done)
+	if sf.forwarder != nil {
+		sf.forwarder.Close()
+	}
+}
+
+func (sf *serviceForwarder) isClosed() bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.closed
+}
+
+// forward picks a ready endpoint pod, other than the excluded one if possible, and forwards the service ports to it
+func (sf *serviceForwarder) forward(excludedPod string, portForwardTimeout time.Duration) error {
+	sc := sf.serviceConnection
+	podName, endpointPorts, err := sf.env.readyServiceEndpoint(sc.ServiceName, excludedPod)
+	if err != nil {
+		return err
+	}
+	targetPorts := map[string]int{}
+	for portName := range sc.RemotePorts {
+		if targetPort, ok := endpointPorts[portName]; ok {
+			targetPorts[portName] = targetPort
+		} else if targetPort, ok := endpointPorts[""]; ok && len(sc.RemotePorts) == 1 {
+			// endpoints of an unnamed single port service have an unnamed port as well
+			targetPorts[portName] = targetPort
+		}
+	}
+	if len(targetPorts) == 0 {
+		return fmt.Errorf("service %s has no endpoint ports to forward", sc.ServiceName)
+	}
+	rules := make([]string, 0)
+	previousPorts := sc.snapshot().LocalPorts
+	for portName, targetPort := range targetPorts {
+		// Reuse local ports on failover so the already handed out local URLs keep working
+		rules = append(rules, fmt.Sprintf("%d:%d", previousPorts[portName], targetPort))
+	}
+	forwarder, forwardedPorts, err := sf.env.forwardPodPorts(podName, rules, portForwardTimeout)
+	if err != nil {
+		return err
+	}
+	sf.mu.Lock()
+	if sf.closed {
+		sf.mu.Unlock()
+		forwarder.Close()
+		return nil
+	}
+	sf.forwarder = forwarder
+	localPorts := copyMap(previousPorts)
+	if localPorts == nil {
+		localPorts = map[string]int{}
+	}
+	for portName, targetPort := range targetPorts {
+		for _, forwardedPort := range forwardedPorts {
+			if int(forwardedPort.Remote) == targetPort {
+				localPorts[portName] = int(forwardedPort.Local)
+			}
+		}
+	}
+	sc.mu.Lock()
+	sc.PodName = podName
+	sc.LocalPorts = localPorts
+	sc.mu.Unlock()
+	sf.mu.Unlock()
+	log.Info().
+		Str("Service", sc.ServiceName).
+		Str("Pod", podName).
+		Interface("LocalPorts", localPorts).
+		Msg("Forwarded service ports")
+	go sf.failover(forwarder, podName, portForwardTimeout)
+	return nil
+}
+
+// failover waits until the forwarded pod is lost or stops being a ready endpoint of the service, then forwards the
+// service to another endpoint
+func (sf *serviceForwarder) failover(forwarder *podForwarder, podName string, portForwardTimeout time.Duration) {
+	ticker := time.NewTicker(ServiceFailoverInterval)
+	defer ticker.Stop()
+	for lost := false; !lost; {
+		select {
+		case <-sf.done:
+			return
+		case <-forwarder.Stopped():
+			lost = true
+		case <-ticker.C:
+			lost = !sf.env.isReadyServiceEndpoint(sf.serviceConnection.ServiceName, podName)
+		}
+	}
+	forwarder.Close()
+	for !sf.isClosed() {
+		log.Warn().
+			Str("Service", sf.serviceConnection.ServiceName).
+			Str("Pod", podName).
+			Msg("Lost connection to service endpoint, failing over")
+		err := sf.forward(podName, portForwardTimeout)
+		if err == nil {
+			return
+		}
+		log.Warn().Err(err).Str("Service", sf.serviceConnection.ServiceName).Msg("Failed to fail over service")
+		select {
+		case <-sf.done:
+			return
+		case <-time.After(ServiceFailoverInterval):
+		}
+	}
+}
+
+// runServiceForwarder forwards the service ports to one of its ready endpoints and keeps them forwarded
+func (k *Environment) runServiceForwarder(serviceConnection *ServiceConnection, portForwardTimeout time.Duration) error {
+	sf := &serviceForwarder{
+		env:               k,
+		serviceConnection: serviceConnection,
+		done:              make(chan struct{}),
+	}
+	if err := sf.forward("", portForwardTimeout); err != nil {
+		return errors.Wrapf(err, "failed to forward service %s", serviceConnection.ServiceName)
+	}
+	k.serviceForwarders = append(k.serviceForwarders, sf)
+	return nil
+}
+
+// readyServiceEndpoint returns a ready pod backing the service along with the target ports by the service port name,
+// the excluded pod is only returned when no other endpoint is ready
+func (k *Environment) readyServiceEndpoint(serviceName string, excludedPod string) (string, map[string]int, error) {
+	endpoints, err := k.k8sClient.CoreV1().Endpoints(k.Namespace).Get(context.Background(), serviceName, metaV1.GetOptions{})
+	if err != nil {
+		return "", nil, err
+	}
+	var fallbackPod string
+	var fallbackPorts map[string]int
+	for _, subset := range endpoints.Subsets {
+		targetPorts := map[string]int{}
+		for _, port := range subset.Ports {
+			targetPorts[port.Name] = int(port.Port)
+		}
+		for _, address := range subset.Addresses {
+			if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+				continue
+			}
+			if address.TargetRef.Name == excludedPod {
+				fallbackPod, fallbackPorts = excludedPod, targetPorts
+				continue
+			}
+			return address.TargetRef.Name, targetPorts, nil
+		}
+	}
+	if fallbackPod != "" {
+		return fallbackPod, fallbackPorts, nil
+	}
+	return "", nil, fmt.Errorf("service %s has no ready endpoints", serviceName)
+}
+
+// isReadyServiceEndpoint checks whether the pod is still a ready endpoint of the service
+func (k *Environment) isReadyServiceEndpoint(serviceName string, podName string) bool {
+	endpoints, err := k.k8sClient.CoreV1().Endpoints(k.Namespace).Get(context.Background(), serviceName, metaV1.GetOptions{})
+	if err != nil {
+		// don't fail over on API hiccups, a lost pod will also close the forwarder
+		log.Debug().Err(err).Str("Service", serviceName).Msg("Failed to check service endpoints")
+		return true
+	}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.TargetRef != nil && address.TargetRef.Name == podName {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package environment_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+// portForwardServer is an API server accepting port forwarding to any pod, it records the pods forwarded to
+type portForwardServer struct {
+	mu   sync.Mutex
+	pods []string
+}
+
+func newPortForwardServer(t *testing.T) (*portForwardServer, *rest.Config) {
+	s := &portForwardServer{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.pods = append(s.pods, r.URL.Path)
+		s.mu.Unlock()
+		if _, err := httpstream.Handshake(r, w, []string{"portforward.k8s.io"}); err != nil {
+			return
+		}
+		conn := spdy.NewResponseUpgrader().UpgradeResponse(w, r, func(httpstream.Stream, <-chan struct{}) error { return nil })
+		if conn == nil {
+			return
+		}
+		<-conn.CloseChan()
+	}))
+	t.Cleanup(server.Close)
+	return s, &rest.Config{Host: server.URL, TLSClientConfig: rest.TLSClientConfig{Insecure: true}}
+}
+
+func (s *portForwardServer) forwardedPods() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.pods...)
+}
+
+func serviceEndpoints(notReady string, ready ...string) *v1.Endpoints {
+	subset := v1.EndpointSubset{
+		Ports:             []v1.EndpointPort{{Name: "access", Port: 6688}},
+		Addresses:         []v1.EndpointAddress{{IP: "10.0.0.9"}},
+		NotReadyAddresses: []v1.EndpointAddress{{IP: "10.0.0.8", TargetRef: &v1.ObjectReference{Kind: "Pod", Name: notReady}}},
+	}
+	for _, pod := range ready {
+		subset.Addresses = append(subset.Addresses, v1.EndpointAddress{IP: "10.0.0.1", TargetRef: &v1.ObjectReference{Kind: "Pod", Name: pod}})
+	}
+	return &v1.Endpoints{
+		ObjectMeta: metaV1.ObjectMeta{Name: "chainlink-node", Namespace: "env"},
+		Subsets:    []v1.EndpointSubset{subset},
+	}
+}
+
+func TestConnectServiceFailover(t *testing.T) {
+	server, k8sConfig := newPortForwardServer(t)
+	client := fake.NewSimpleClientset(serviceEndpoints("chainlink-node-c", "chainlink-node-a", "chainlink-node-b"))
+	e := environment.NewEnvironmentWithClientConfig(&environment.Config{Namespace: "env"}, client, k8sConfig)
+	chart := &environment.HelmChart{
+		ReleaseName: "chainlink",
+		Index:       1,
+		ServiceConnections: environment.ServiceConnections{
+			"chainlink-node": {ServiceName: "chainlink-node", RemotePorts: map[string]int{"access": 80}},
+		},
+	}
+	require.NoError(t, e.AddChart(chart))
+	require.NoError(t, chart.ConnectService("chainlink-node"))
+	defer e.Disconnect()
+
+	service := chart.ServiceConnections["chainlink-node"]
+	require.Equal(t, "chainlink-node-a", service.ForwardedPod(), "the first ready endpoint pod is forwarded to")
+	u, err := service.LocalURL("access", environment.HTTP)
+	require.NoError(t, err)
+
+	_, err = client.CoreV1().Endpoints("env").Update(context.Background(), serviceEndpoints("chainlink-node-a", "chainlink-node-b"), metaV1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return service.ForwardedPod() == "chainlink-node-b"
+	}, 3*environment.ServiceFailoverInterval, 50*time.Millisecond, "the service fails over once its pod isn't ready")
+	failedOver, err := service.LocalURL("access", environment.HTTP)
+	require.NoError(t, err)
+	require.Equal(t, u.String(), failedOver.String(), "the local port is kept")
+	require.Equal(t, []string{
+		"/api/v1/namespaces/env/pods/chainlink-node-a/portforward",
+		"/api/v1/namespaces/env/pods/chainlink-node-b/portforward",
+	}, server.forwardedPods())
+}
+
+func TestDumpConfigServiceConnections(t *testing.T) {
+	t.Parallel()
+	connection := &environment.ServiceConnection{
+		ServiceName: "chainlink-node",
+		PodName:     "chainlink-node-0",
+		RemotePorts: map[string]int{"access": 6688},
+		LocalPorts:  map[string]int{"access": 53412},
+	}
+	config := &environment.Config{
+		Namespace: "chainlink-abcde",
+		Charts: environment.Charts{
+			"chainlink": {Index: 1, ServiceConnections: environment.ServiceConnections{"chainlink-node": connection}},
+		},
+	}
+	for _, name := range []string{"env.yaml", "env.json"} {
+		store := &environment.FileStore{Path: filepath.Join(t.TempDir(), name)}
+		require.NoError(t, store.Save(config))
+		loaded, err := store.Load()
+		require.NoError(t, err)
+		require.Equal(t, connection, loaded.Charts["chainlink"].ServiceConnections["chainlink-node"], name)
+	}
+	require.Equal(t, "chainlink-node-0", connection.ForwardedPod())
+}
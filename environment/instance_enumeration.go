@@ -0,0 +1,281 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	// StatefulSetOrdinalEnumeration numbers StatefulSet pods by their ordinal, other pods in creation order
+	StatefulSetOrdinalEnumeration = "statefulset-ordinal"
+	// NameHashEnumeration numbers pods in the order of the hash of their names
+	NameHashEnumeration = "name-hash"
+	// CreationOrderEnumeration numbers pods in the order they were created
+	CreationOrderEnumeration = "creation-order"
+
+	// instanceWatchRetryInterval how long to wait before watching pods again once a watch ends
+	instanceWatchRetryInterval = 5 * time.Second
+)
+
+// instanceOrders orders pods that aren't enumerated yet, keyed by enumeration strategy
+var instanceOrders = map[string]func(a, b *v1.Pod) bool{
+	StatefulSetOrdinalEnumeration: createdBefore,
+	NameHashEnumeration:           nameHashBefore,
+	CreationOrderEnumeration:      createdBefore,
+}
+
+// EnumeratePods returns the instance of every running pod of an app keyed by pod name. Pods keep the instance label
+// they already have, so a recreated pod takes over the instance of the pod it replaces, the others get the lowest
+// free instances in the order of the strategy. An empty strategy is the StatefulSet ordinal strategy
+func EnumeratePods(pods []v1.Pod, strategy string) (map[string]int, error) {
+	if strategy == "" {
+		strategy = StatefulSetOrdinalEnumeration
+	}
+	before, ok := instanceOrders[strategy]
+	if !ok {
+		return nil, fmt.Errorf("instance enumeration strategy %s doesn't exist", strategy)
+	}
+	instances := map[string]int{}
+	taken := map[int]bool{}
+	var pending []*v1.Pod
+	if strategy == StatefulSetOrdinalEnumeration {
+		for i := range pods {
+			if ordinal, ok := statefulSetOrdinal(&pods[i]); ok && isActivePod(&pods[i]) {
+				instances[pods[i].Name] = ordinal
+				taken[ordinal] = true
+			}
+		}
+	}
+	for i := range pods {
+		pod := &pods[i]
+		if _, ok := instances[pod.Name]; ok || !isActivePod(pod) {
+			continue
+		}
+		instance, err := strconv.Atoi(pod.Labels[InstanceEnumerationLabelKey])
+		if err != nil || instance < 0 || taken[instance] {
+			pending = append(pending, pod)
+			continue
+		}
+		instances[pod.Name] = instance
+		taken[instance] = true
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return before(pending[i], pending[j])
+	})
+	next := 0
+	for _, pod := range pending {
+		for taken[next] {
+			next++
+		}
+		instances[pod.Name] = next
+		taken[next] = true
+	}
+	return instances, nil
+}
+
+// instanceEnumeration resolves the enumeration strategy of the chart, falling back to the one of the environment
+func (hc *HelmChart) instanceEnumeration() string {
+	if hc.InstanceEnumeration == "" && hc.env != nil {
+		return hc.env.Config.InstanceEnumeration
+	}
+	return hc.InstanceEnumeration
+}
+
+// addInstanceLabel labels the pods of an app with their instance, only pods whose instance changed are patched
+func (hc *HelmChart) addInstanceLabel(app string) error {
+	k8sPods := hc.env.k8sClient.CoreV1().Pods(hc.namespaceName)
+	l, err := k8sPods.List(context.Background(), metaV1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", AppEnumerationLabelKey, app),
+	})
+	if err != nil {
+		return err
+	}
+	instances, err := EnumeratePods(l.Items, hc.instanceEnumeration())
+	if err != nil {
+		return err
+	}
+	for _, pod := range l.Items {
+		instance, ok := instances[pod.Name]
+		if !ok || pod.Labels[InstanceEnumerationLabelKey] == strconv.Itoa(instance) {
+			continue
+		}
+		labelPatch := fmt.Sprintf(`{"metadata":{"labels":{"%s":"%d"}}}`, InstanceEnumerationLabelKey, instance)
+		_, err := k8sPods.Patch(context.Background(), pod.Name, types.StrategicMergePatchType, []byte(labelPatch), metaV1.PatchOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to update labels %s for pod %s", labelPatch, pod.Name)
+		}
+		log.Debug().Str("Pod", pod.Name).Int("Instance", instance).Msg("Enumerated app instance")
+	}
+	return nil
+}
+
+// watchInstances labels pods of the apps of the chart that are created or recreated until stop is closed
+func (hc *HelmChart) watchInstances(stop <-chan struct{}) {
+	apps := map[string]bool{}
+	hc.ChartConnections.Range(func(_ string, c *ChartConnection) bool {
+		apps[c.App] = true
+		return true
+	})
+	for {
+		w, err := hc.env.k8sClient.CoreV1().Pods(hc.namespaceName).Watch(context.Background(), metaV1.ListOptions{
+			LabelSelector: AppEnumerationLabelKey,
+		})
+		if err != nil {
+			log.Error().Err(err).Str("Release", hc.ReleaseName).Msg("Failed to watch pods for instance enumeration")
+		} else if done := hc.relabelRecreatedPods(w, apps, stop); done {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(instanceWatchRetryInterval):
+		}
+	}
+}
+
+// relabelRecreatedPods enumerates the app of every pod event without an instance label and refreshes the connections
+// of instances whose pod was recreated, it returns true once stop is closed and false if the watch ended
+func (hc *HelmChart) relabelRecreatedPods(w watch.Interface, apps map[string]bool, stop <-chan struct{}) bool {
+	defer w.Stop()
+	for {
+		select {
+		case <-stop:
+			return true
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return false
+			}
+			pod, isPod := event.Object.(*v1.Pod)
+			if !isPod || event.Type == watch.Deleted {
+				continue
+			}
+			app, hasApp := pod.Labels[AppEnumerationLabelKey]
+			if !hasApp || !apps[app] {
+				continue
+			}
+			if _, hasInstance := pod.Labels[InstanceEnumerationLabelKey]; hasInstance {
+				hc.refreshConnections(pod)
+				continue
+			}
+			if err := hc.addInstanceLabel(app); err != nil {
+				log.Error().Err(err).Str("App", app).Msg("Failed to enumerate app instances")
+			}
+		}
+	}
+}
+
+// refreshConnections points the connections of the instance of a running pod at it if they still refer to the pod it
+// replaced, ports that were forwarded to the replaced pod are forwarded to it on the same local ports
+func (hc *HelmChart) refreshConnections(pod *v1.Pod) {
+	if pod.Status.Phase != v1.PodRunning || pod.Status.PodIP == "" || !isActivePod(pod) {
+		return
+	}
+	instance, err := strconv.Atoi(pod.Labels[InstanceEnumerationLabelKey])
+	if err != nil {
+		return
+	}
+	query := hc.ChartConnections.Query().App(pod.Labels[AppEnumerationLabelKey]).Instance(instance)
+	for _, c := range query.Connections() {
+		connection := c.snapshot()
+		if connection.PodName == pod.Name && connection.PodIP == pod.Status.PodIP {
+			continue
+		}
+		log.Info().
+			Str("App", connection.App).
+			Int("Instance", instance).
+			Str("Container", connection.Container).
+			Str("Pod", pod.Name).
+			Str("PreviousPod", connection.PodName).
+			Msg("Refreshing the connection of a recreated pod")
+		c.mu.Lock()
+		c.PodName = pod.Name
+		c.PodIP = pod.Status.PodIP
+		c.PodDNS = podDNSName(pod)
+		forwarder := c.forwarder
+		c.forwarder = nil
+		c.mu.Unlock()
+		if forwarder == nil {
+			continue
+		}
+		hc.env.closeForwarder(forwarder)
+		if err := hc.env.reforwardPod(c, time.Second*30); err != nil {
+			log.Error().Err(err).Str("Pod", pod.Name).Msg("Failed to forward the ports of a recreated pod")
+		}
+	}
+}
+
+// StartInstanceWatcher keeps the instance labels of all charts stable while pods are recreated, until the environment
+// is disconnected
+func (k *Environment) StartInstanceWatcher() {
+	if k.instanceWatcher != nil {
+		return
+	}
+	k.instanceWatcher = make(chan struct{})
+	for _, c := range k.Charts {
+		go c.watchInstances(k.instanceWatcher)
+	}
+}
+
+// StopInstanceWatcher stops relabelling recreated pods
+func (k *Environment) StopInstanceWatcher() {
+	if k.instanceWatcher == nil {
+		return
+	}
+	close(k.instanceWatcher)
+	k.instanceWatcher = nil
+}
+
+// statefulSetOrdinal returns the ordinal of a pod owned by a StatefulSet, the suffix of its name
+func statefulSetOrdinal(pod *v1.Pod) (int, bool) {
+	owner := metaV1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "StatefulSet" {
+		return 0, false
+	}
+	i := strings.LastIndex(pod.Name, "-")
+	if i < 0 {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(pod.Name[i+1:])
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// isActivePod returns false for pods that are being deleted or finished running
+func isActivePod(pod *v1.Pod) bool {
+	return pod.DeletionTimestamp == nil && pod.Status.Phase != v1.PodSucceeded && pod.Status.Phase != v1.PodFailed
+}
+
+func createdBefore(a, b *v1.Pod) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Name < b.Name
+}
+
+func nameHashBefore(a, b *v1.Pod) bool {
+	ha, hb := nameHash(a.Name), nameHash(b.Name)
+	if ha != hb {
+		return ha < hb
+	}
+	return a.Name < b.Name
+}
+
+func nameHash(name string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return h.Sum32()
+}
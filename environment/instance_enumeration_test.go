@@ -0,0 +1,123 @@
+package environment_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPod(name string, created int, owner string, labels map[string]string) v1.Pod {
+	pod := v1.Pod{ObjectMeta: metaV1.ObjectMeta{
+		Name:              name,
+		Labels:            labels,
+		CreationTimestamp: metaV1.NewTime(time.Unix(int64(created), 0)),
+	}}
+	if owner != "" {
+		controller := true
+		pod.OwnerReferences = []metaV1.OwnerReference{{Kind: owner, Name: "owner", Controller: &controller}}
+	}
+	return pod
+}
+
+func TestEnumeratePodsStatefulSetOrdinal(t *testing.T) {
+	t.Parallel()
+	pods := []v1.Pod{
+		testPod("chainlink-2", 1, "StatefulSet", nil),
+		testPod("chainlink-0", 3, "StatefulSet", map[string]string{"instance": "2"}),
+		testPod("chainlink-1", 2, "StatefulSet", nil),
+	}
+	instances, err := environment.EnumeratePods(pods, "")
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"chainlink-0": 0, "chainlink-1": 1, "chainlink-2": 2}, instances)
+}
+
+func TestEnumeratePodsCreationOrder(t *testing.T) {
+	t.Parallel()
+	pods := []v1.Pod{
+		testPod("adapter-c", 3, "ReplicaSet", nil),
+		testPod("adapter-a", 2, "ReplicaSet", nil),
+		testPod("adapter-b", 1, "ReplicaSet", nil),
+	}
+	instances, err := environment.EnumeratePods(pods, environment.CreationOrderEnumeration)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"adapter-b": 0, "adapter-a": 1, "adapter-c": 2}, instances)
+
+	// adapter-b is recreated as adapter-d, it takes over instance 0 although it's the newest pod
+	deleted := metaV1.NewTime(time.Unix(4, 0))
+	pods = []v1.Pod{
+		testPod("adapter-a", 2, "ReplicaSet", map[string]string{"instance": "1"}),
+		testPod("adapter-c", 3, "ReplicaSet", map[string]string{"instance": "2"}),
+		testPod("adapter-d", 5, "ReplicaSet", nil),
+		testPod("adapter-b", 1, "ReplicaSet", map[string]string{"instance": "0"}),
+	}
+	pods[3].DeletionTimestamp = &deleted
+	instances, err = environment.EnumeratePods(pods, environment.CreationOrderEnumeration)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"adapter-a": 1, "adapter-c": 2, "adapter-d": 0}, instances)
+}
+
+func TestEnumeratePodsNameHash(t *testing.T) {
+	t.Parallel()
+	pods := []v1.Pod{testPod("geth-x", 1, "", nil), testPod("geth-y", 2, "", nil), testPod("geth-z", 3, "", nil)}
+	instances, err := environment.EnumeratePods(pods, environment.NameHashEnumeration)
+	require.NoError(t, err)
+	reversed := []v1.Pod{pods[2], pods[1], pods[0]}
+	reversedInstances, err := environment.EnumeratePods(reversed, environment.NameHashEnumeration)
+	require.NoError(t, err)
+	require.Equal(t, instances, reversedInstances)
+
+	_, err = environment.EnumeratePods(pods, "pod-ip")
+	require.Error(t, err)
+}
+
+func TestInstanceWatcherRefreshesRecreatedPods(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	e := environment.NewEnvironmentWithClient(&environment.Config{Namespace: "env"}, client)
+	chart := &environment.HelmChart{
+		ReleaseName: "chainlink",
+		Index:       1,
+		ChartConnections: environment.ChartConnections{
+			"chainlink-node_0_node": {
+				App:         "chainlink-node",
+				Container:   "node",
+				PodName:     "chainlink-node-abcde",
+				PodIP:       "10.0.0.1",
+				RemotePorts: map[string]int{"access": 6688},
+			},
+		},
+	}
+	require.NoError(t, e.AddChart(chart))
+	e.StartInstanceWatcher()
+	defer e.StopInstanceWatcher()
+
+	pod := testPod("chainlink-node-fghij", 1, "", map[string]string{"app": "chainlink-node", "instance": "0"})
+	pod.Status = v1.PodStatus{Phase: v1.PodRunning, PodIP: "10.0.0.2"}
+	pods := client.CoreV1().Pods("env")
+	_, err := pods.Create(context.Background(), &pod, metaV1.CreateOptions{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		// connections are read while the watcher refreshes them
+		require.NotEmpty(t, e.Charts.ConnectionVariables())
+		require.NotEmpty(t, e.Charts.Query().PodNames())
+		d, err := json.Marshal(chart.ChartConnections["chainlink-node_0_node"])
+		require.NoError(t, err)
+		var connection environment.ChartConnection
+		require.NoError(t, json.Unmarshal(d, &connection))
+		if connection.PodName == "chainlink-node-fghij" && connection.PodIP == "10.0.0.2" {
+			return true
+		}
+		// fake watches only see changes made after they start
+		_, err = pods.Update(context.Background(), &pod, metaV1.UpdateOptions{})
+		require.NoError(t, err)
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+}
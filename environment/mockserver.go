@@ -0,0 +1,117 @@
+package environment
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// MockserverExpectationsValuesKey values key of the mockserver-config chart the expectations are rendered into
+const MockserverExpectationsValuesKey = "expectations"
+
+// MockserverExpectation a response mockserver returns for requests matching a path and method, e.g. the response of
+// an external adapter
+type MockserverExpectation struct {
+	ID       string              `yaml:"id,omitempty" json:"id,omitempty" envconfig:"id"`
+	Path     string              `yaml:"path" json:"path" envconfig:"path"`
+	Method   string              `yaml:"method,omitempty" json:"method,omitempty" envconfig:"method"`
+	Status   int                 `yaml:"status,omitempty" json:"status,omitempty" envconfig:"status"`
+	Body     interface{}         `yaml:"body,omitempty" json:"body,omitempty" envconfig:"body"`
+	Sequence []interface{}       `yaml:"sequence,omitempty" json:"sequence,omitempty" envconfig:"sequence"`
+	Delay    MarshalSafeDuration `yaml:"delay,omitempty" json:"delay,omitempty" envconfig:"delay"`
+	Times    int                 `yaml:"times,omitempty" json:"times,omitempty" envconfig:"times"`
+}
+
+// Render returns the expectation in the JSON format of the mockserver API, a sequence of bodies becomes one
+// expectation per body, each matching once in order, the last one matching the remaining times
+func (m *MockserverExpectation) Render() []map[string]interface{} {
+	bodies := m.Sequence
+	if len(bodies) == 0 {
+		bodies = []interface{}{m.Body}
+	}
+	id := m.ID
+	if id == "" {
+		id = fmt.Sprintf("%s-%s", strings.ToLower(m.method()), strings.Trim(m.Path, "/"))
+	}
+	rendered := make([]map[string]interface{}, 0, len(bodies))
+	for i, body := range bodies {
+		times := map[string]interface{}{"unlimited": true}
+		if i < len(bodies)-1 {
+			times = map[string]interface{}{"remainingTimes": 1, "unlimited": false}
+		} else if m.Times > 0 {
+			times = map[string]interface{}{"remainingTimes": m.Times, "unlimited": false}
+		}
+		expectation := map[string]interface{}{
+			"id":           id,
+			"priority":     len(bodies) - i,
+			"httpRequest":  m.request(),
+			"httpResponse": m.response(body),
+			"times":        times,
+		}
+		if len(bodies) > 1 {
+			expectation["id"] = fmt.Sprintf("%s-%d", id, i)
+		}
+		rendered = append(rendered, expectation)
+	}
+	return rendered
+}
+
+// RenderMockserverExpectations renders all expectations in the JSON format of the mockserver API
+func RenderMockserverExpectations(expectations []*MockserverExpectation) []interface{} {
+	rendered := []interface{}{}
+	for _, m := range expectations {
+		for _, expectation := range m.Render() {
+			rendered = append(rendered, expectation)
+		}
+	}
+	return rendered
+}
+
+// request returns the request matcher of the expectation
+func (m *MockserverExpectation) request() map[string]interface{} {
+	return map[string]interface{}{"method": m.method(), "path": m.Path}
+}
+
+func (m *MockserverExpectation) method() string {
+	if m.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(m.Method)
+}
+
+func (m *MockserverExpectation) response(body interface{}) map[string]interface{} {
+	status := m.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := map[string]interface{}{"statusCode": status}
+	switch b := body.(type) {
+	case nil:
+	case string:
+		response["body"] = b
+	default:
+		response["body"] = map[string]interface{}{"type": "JSON", "json": b}
+	}
+	if m.Delay > 0 {
+		response["delay"] = map[string]interface{}{"timeUnit": "MILLISECONDS", "value": m.Delay.AsTimeDuration().Milliseconds()}
+	}
+	return response
+}
+
+// Verification returns a mockserver verification that the expectation was matched between atLeast and atMost times
+func (m *MockserverExpectation) Verification(atLeast, atMost int) map[string]interface{} {
+	return map[string]interface{}{
+		"httpRequest": m.request(),
+		"times":       map[string]interface{}{"atLeast": atLeast, "atMost": atMost},
+	}
+}
+
+// withMockserverExpectations adds the mockserver expectations of the environment to the values of the
+// mockserver-config chart
+func (hc *HelmChart) withMockserverExpectations(chartName string, values map[string]interface{}) map[string]interface{} {
+	if chartName != mockServerConfigChartName || hc.env == nil || len(hc.env.Config.MockserverExpectations) == 0 {
+		return values
+	}
+	values[MockserverExpectationsValuesKey] = RenderMockserverExpectations(hc.env.Config.MockserverExpectations)
+	return values
+}
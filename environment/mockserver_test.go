@@ -0,0 +1,67 @@
+package environment_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMockserverExpectations(t *testing.T) {
+	var config environment.Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+mockserver_expectations:
+  - path: /variable
+    body:
+      id: ""
+      data:
+        result: 5
+    delay: 100ms
+  - path: /price
+    method: post
+    status: 500
+    sequence: ["error", "ok"]
+    times: 3
+`), &config))
+	require.Equal(t, 100*time.Millisecond, config.MockserverExpectations[0].Delay.AsTimeDuration())
+
+	d, err := json.Marshal(config.MockserverExpectations[0])
+	require.NoError(t, err)
+	require.Contains(t, string(d), `"delay":"100ms"`)
+	var expectation environment.MockserverExpectation
+	require.NoError(t, json.Unmarshal(d, &expectation))
+	require.Equal(t, config.MockserverExpectations[0].Delay, expectation.Delay)
+
+	rendered, err := json.Marshal(environment.RenderMockserverExpectations(config.MockserverExpectations))
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{
+			"id": "get-variable",
+			"priority": 1,
+			"httpRequest": {"method": "GET", "path": "/variable"},
+			"httpResponse": {
+				"statusCode": 200,
+				"body": {"type": "JSON", "json": {"id": "", "data": {"result": 5}}},
+				"delay": {"timeUnit": "MILLISECONDS", "value": 100}
+			},
+			"times": {"unlimited": true}
+		},
+		{
+			"id": "post-price-0",
+			"priority": 2,
+			"httpRequest": {"method": "POST", "path": "/price"},
+			"httpResponse": {"statusCode": 500, "body": "error"},
+			"times": {"remainingTimes": 1, "unlimited": false}
+		},
+		{
+			"id": "post-price-1",
+			"priority": 1,
+			"httpRequest": {"method": "POST", "path": "/price"},
+			"httpResponse": {"statusCode": 500, "body": "ok"},
+			"times": {"remainingTimes": 3, "unlimited": false}
+		}
+	]`, string(rendered))
+}
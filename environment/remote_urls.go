@@ -0,0 +1,132 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// PodIPRemoteURLs remote URLs use the pod IP, which changes whenever the pod restarts
+	PodIPRemoteURLs = "pod-ip"
+	// ServiceDNSRemoteURLs remote URLs use the DNS name and port of a service the pod is an endpoint of
+	ServiceDNSRemoteURLs = "service-dns"
+	// PodDNSRemoteURLs remote URLs use the per-pod DNS name StatefulSet pods get from their headless service
+	PodDNSRemoteURLs = "pod-dns"
+
+	// ClusterDomain DNS domain of the cluster services are resolvable in
+	ClusterDomain = "cluster.local"
+)
+
+// serviceDNSName returns the fully qualified DNS name of a service
+func serviceDNSName(serviceName, namespace string) string {
+	return fmt.Sprintf("%s.%s.svc.%s", serviceName, namespace, ClusterDomain)
+}
+
+// podDNSName returns the fully qualified DNS name of a pod with a hostname and the subdomain of a headless service,
+// empty if it doesn't have one
+func podDNSName(pod *v1.Pod) string {
+	if pod.Spec.Hostname == "" || pod.Spec.Subdomain == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s.%s", pod.Spec.Hostname, serviceDNSName(pod.Spec.Subdomain, pod.Namespace))
+}
+
+// RemoteAddress returns the in-cluster host and port of a port of the connection in a remote URL mode, an empty mode
+// is the pod IP mode
+func (c *ChartConnection) RemoteAddress(portName string, mode string) (string, int, error) {
+	remotePort, ok := c.RemotePorts[portName]
+	if !ok {
+		return "", 0, fmt.Errorf("port %s of pod %s doesn't exist", portName, c.PodName)
+	}
+	switch mode {
+	case "", PodIPRemoteURLs:
+		return c.PodIP, remotePort, nil
+	case PodDNSRemoteURLs:
+		if c.PodDNS == "" {
+			return "", 0, fmt.Errorf("pod %s has no DNS name, it must be a StatefulSet pod with a headless service", c.PodName)
+		}
+		return c.PodDNS, remotePort, nil
+	case ServiceDNSRemoteURLs:
+		host, ok := c.ServiceHosts[portName]
+		if !ok {
+			return "", 0, fmt.Errorf("port %s of pod %s isn't exposed by any service", portName, c.PodName)
+		}
+		return host, c.ServicePorts[portName], nil
+	}
+	return "", 0, fmt.Errorf("remote URL mode %s doesn't exist", mode)
+}
+
+// remoteURLMode resolves the remote URL mode of the chart, falling back to the one of the environment
+func (hc *HelmChart) remoteURLMode() string {
+	if hc.RemoteURLMode == "" && hc.env != nil {
+		return hc.env.Config.RemoteURLMode
+	}
+	return hc.RemoteURLMode
+}
+
+// recordServiceHosts records the DNS names and ports of the release services on the pod connections that are endpoints
+// of them, a port exposed by several services keeps the first one, services with a cluster IP go before headless ones
+func (hc *HelmChart) recordServiceHosts(services []*v1.Service) error {
+	sort.SliceStable(services, func(i, j int) bool {
+		return services[i].Spec.ClusterIP != v1.ClusterIPNone && services[j].Spec.ClusterIP == v1.ClusterIPNone
+	})
+	for _, service := range services {
+		if err := hc.recordServiceHost(service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hc *HelmChart) recordServiceHost(service *v1.Service) error {
+	endpoints, err := hc.env.k8sClient.CoreV1().Endpoints(hc.namespaceName).Get(context.Background(), service.Name, metaV1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	host := serviceDNSName(service.Name, hc.namespaceName)
+	for _, port := range service.Spec.Ports {
+		for _, subset := range endpoints.Subsets {
+			for _, endpointPort := range subset.Ports {
+				if endpointPort.Name != port.Name {
+					continue
+				}
+				addresses := append(append([]v1.EndpointAddress{}, subset.Addresses...), subset.NotReadyAddresses...)
+				for _, address := range addresses {
+					if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+						continue
+					}
+					hc.ChartConnections.Range(func(_ string, c *ChartConnection) bool {
+						if c.snapshot().PodName == address.TargetRef.Name {
+							c.setServiceHost(int(endpointPort.Port), host, int(port.Port))
+						}
+						return true
+					})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// setServiceHost records a service address of the container ports with the target port number, unless they already
+// have one
+func (c *ChartConnection) setServiceHost(targetPort int, host string, servicePort int) {
+	for portName, containerPort := range c.RemotePorts {
+		if containerPort != targetPort {
+			continue
+		}
+		if _, ok := c.ServiceHosts[portName]; ok {
+			continue
+		}
+		if c.ServiceHosts == nil {
+			c.ServiceHosts = map[string]string{}
+			c.ServicePorts = map[string]int{}
+		}
+		c.ServiceHosts[portName] = host
+		c.ServicePorts[portName] = servicePort
+	}
+}
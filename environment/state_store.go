@@ -0,0 +1,212 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// FileStateStore keeps the config of an environment in the environment file only, the default
+	FileStateStore = "file"
+	// SecretStateStore also keeps the config of an environment in a Secret of its namespace
+	SecretStateStore = "secret"
+	// ConfigMapStateStore also keeps the config of an environment in a ConfigMap of its namespace
+	ConfigMapStateStore = "configmap"
+
+	// StateObjectName name of the Secret or ConfigMap holding the config of an environment
+	StateObjectName = "helmenv-state"
+	// stateKey is a yaml file name so the state decodes like an environment file
+	stateKey = "config.yaml"
+)
+
+// StateStore saves and loads the config of a deployed environment
+type StateStore interface {
+	Save(config *Config) error
+	Load() (*Config, error)
+}
+
+// FileStore keeps the config in an environment file
+type FileStore struct {
+	Path string
+}
+
+// Save writes the config to the environment file, as json if the file has a json extension
+func (s *FileStore) Save(config *Config) error {
+	if filepath.Ext(s.Path) == ".json" {
+		return DumpConfigJson(config, s.Path)
+	}
+	return DumpConfig(config, s.Path)
+}
+
+// Load reads the config from the environment file
+func (s *FileStore) Load() (*Config, error) {
+	return ReadConfigFile(s.Path)
+}
+
+// ClusterStore keeps the config in a Secret or ConfigMap of the namespace of the environment, so it can be loaded
+// from any machine with access to the cluster
+type ClusterStore struct {
+	client    kubernetes.Interface
+	namespace string
+	kind      string
+}
+
+// NewClusterStore creates a store keeping the config in the namespace, kind is SecretStateStore or ConfigMapStateStore
+func NewClusterStore(client kubernetes.Interface, namespace string, kind string) (*ClusterStore, error) {
+	if kind != SecretStateStore && kind != ConfigMapStateStore {
+		return nil, fmt.Errorf("state store %s can't be kept in a cluster, must be %s or %s", kind, SecretStateStore, ConfigMapStateStore)
+	}
+	return &ClusterStore{client: client, namespace: namespace, kind: kind}, nil
+}
+
+// Save writes the config to the Secret or ConfigMap, creating it if it doesn't exist. Local ports of connections are
+// left out, they only exist on the machine that connected
+func (s *ClusterStore) Save(config *Config) error {
+	config.SchemaVersion = CurrentSchemaVersion
+	if err := checkRedactedValues(config); err != nil {
+		return err
+	}
+	d, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	shared := &Config{}
+	if err := yaml.Unmarshal(d, shared); err != nil {
+		return err
+	}
+	shared.ClearLocalPorts()
+	if d, err = yaml.Marshal(shared); err != nil {
+		return err
+	}
+	meta := metaV1.ObjectMeta{
+		Name:   StateObjectName,
+		Labels: map[string]string{"app.kubernetes.io/managed-by": "helmenv"},
+	}
+	ctx := context.Background()
+	if s.kind == SecretStateStore {
+		secret := &v1.Secret{ObjectMeta: meta, Data: map[string][]byte{stateKey: d}}
+		secrets := s.client.CoreV1().Secrets(s.namespace)
+		_, err = secrets.Update(ctx, secret, metaV1.UpdateOptions{})
+		if k8serrors.IsNotFound(err) {
+			_, err = secrets.Create(ctx, secret, metaV1.CreateOptions{})
+		}
+	} else {
+		configMap := &v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{stateKey: string(d)}}
+		configMaps := s.client.CoreV1().ConfigMaps(s.namespace)
+		_, err = configMaps.Update(ctx, configMap, metaV1.UpdateOptions{})
+		if k8serrors.IsNotFound(err) {
+			_, err = configMaps.Create(ctx, configMap, metaV1.CreateOptions{})
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to save the state of namespace %s in %s %s", s.namespace, s.kind, StateObjectName)
+	}
+	log.Info().Str("Namespace", s.namespace).Str("Store", s.kind).Msg("Environment state saved")
+	return nil
+}
+
+// Load reads the config from the Secret or ConfigMap
+func (s *ClusterStore) Load() (*Config, error) {
+	ctx := context.Background()
+	var contents []byte
+	if s.kind == SecretStateStore {
+		secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, StateObjectName, metaV1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load the state of namespace %s", s.namespace)
+		}
+		contents = secret.Data[stateKey]
+	} else {
+		configMap, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, StateObjectName, metaV1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load the state of namespace %s", s.namespace)
+		}
+		contents = []byte(configMap.Data[stateKey])
+	}
+	config := &Config{}
+	if err := decodeConfig(stateKey, contents, config); err != nil {
+		return nil, err
+	}
+	config.Timeout = config.MarshalSafeTimeout.AsTimeDuration()
+	// like environment files, loaded state is written back when it changes
+	config.Persistent = true
+	log.Info().Str("Namespace", s.namespace).Str("Store", s.kind).Msg("Environment state loaded")
+	return config, nil
+}
+
+// ReadClusterState reads the config kept in a Secret or ConfigMap of the namespace of an environment
+func ReadClusterState(namespace string) (*Config, error) {
+	client, _, err := GetLocalK8sDeps()
+	if err != nil {
+		return nil, err
+	}
+	for _, kind := range []string{SecretStateStore, ConfigMapStateStore} {
+		store, err := NewClusterStore(client, namespace, kind)
+		if err != nil {
+			return nil, err
+		}
+		config, err := store.Load()
+		if err == nil {
+			return config, nil
+		}
+		if !k8serrors.IsNotFound(errors.Cause(err)) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("namespace %s has no environment state, deploy it with state_store set to %s or %s",
+		namespace, SecretStateStore, ConfigMapStateStore)
+}
+
+// LoadEnvironmentFromStore loads an already deployed environment from the config in a store, the environment keeps
+// saving its config to the store
+func LoadEnvironmentFromStore(store StateStore) (*Environment, error) {
+	config, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	environment, err := LoadEnvironment(config)
+	if environment != nil {
+		environment.SetStateStore(store)
+	}
+	return environment, err
+}
+
+// SetStateStore makes the environment save its config to the store instead of the environment file and the store
+// picked by state_store
+func (k *Environment) SetStateStore(store StateStore) {
+	k.stateStore = store
+}
+
+// LoadEnvironmentFromNamespace loads an already deployed environment from the config kept in its namespace
+func LoadEnvironmentFromNamespace(namespace string) (*Environment, error) {
+	config, err := ReadClusterState(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return LoadEnvironment(config)
+}
+
+// clusterStore returns the store of the environment in its namespace, nil if the config is only kept in a file
+func (k *Environment) clusterStore() (*ClusterStore, error) {
+	if k.Namespace == "" || k.Config.StateStore == "" || k.Config.StateStore == FileStateStore {
+		return nil, nil
+	}
+	return NewClusterStore(k.k8sClient, k.Namespace, k.Config.StateStore)
+}
+
+// syncClusterState saves the config to the store of the environment in its namespace if it has one
+func (k *Environment) syncClusterState() error {
+	store, err := k.clusterStore()
+	if err != nil || store == nil {
+		return err
+	}
+	return store.Save(k.Config)
+}
@@ -0,0 +1,120 @@
+package environment_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func stateConfig() *environment.Config {
+	return &environment.Config{
+		Namespace:  "chainlink-abcde",
+		StateStore: environment.SecretStateStore,
+		Charts: environment.Charts{
+			"geth": {
+				Index:          1,
+				RedactedValues: []string{"password"},
+				Values:         map[string]interface{}{"password": "${env:GETH_PASSWORD}"},
+				ChartConnections: environment.ChartConnections{
+					"geth_0_geth-network": {
+						App:         "geth",
+						PodName:     "geth-0",
+						RemotePorts: map[string]int{"ws-rpc": 8546},
+						LocalPorts:  map[string]int{"ws-rpc": 53412},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestClusterStore(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	for _, kind := range []string{environment.SecretStateStore, environment.ConfigMapStateStore} {
+		store, err := environment.NewClusterStore(client, "chainlink-abcde", kind)
+		require.NoError(t, err)
+		config := stateConfig()
+		require.NoError(t, store.Save(config))
+		config.Charts["geth"].Index = 2
+		require.NoError(t, store.Save(config), "an existing state is updated")
+
+		loaded, err := store.Load()
+		require.NoError(t, err)
+		require.True(t, loaded.Persistent)
+		require.Equal(t, "chainlink-abcde", loaded.Namespace)
+		geth := loaded.Charts["geth"]
+		require.Equal(t, 2, geth.Index)
+		require.Equal(t, "${env:GETH_PASSWORD}", geth.Values["password"], "references survive a round trip")
+		connection := geth.ChartConnections["geth_0_geth-network"]
+		require.Equal(t, map[string]int{"ws-rpc": 8546}, connection.RemotePorts)
+		require.Empty(t, connection.LocalPorts, "local ports only exist on the machine that connected")
+		require.Equal(t, 53412, config.Charts["geth"].ChartConnections["geth_0_geth-network"].LocalPorts["ws-rpc"])
+	}
+	_, err := client.CoreV1().Secrets("chainlink-abcde").Get(context.Background(), environment.StateObjectName, metaV1.GetOptions{})
+	require.NoError(t, err)
+	config := stateConfig()
+	config.Charts["geth"].Values["password"] = "hunter2"
+	store, err := environment.NewClusterStore(client, "chainlink-abcde", environment.SecretStateStore)
+	require.NoError(t, err)
+	require.ErrorContains(t, store.Save(config), "value password is redacted but isn't a reference", "literal secrets are rejected up front")
+
+	_, err = environment.NewClusterStore(client, "chainlink-abcde", environment.FileStateStore)
+	require.EqualError(t, err, "state store file can't be kept in a cluster, must be secret or configmap")
+	store, err = environment.NewClusterStore(client, "chainlink-fghij", environment.SecretStateStore)
+	require.NoError(t, err)
+	_, err = store.Load()
+	require.ErrorContains(t, err, "failed to load the state of namespace chainlink-fghij")
+}
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+	store := &environment.FileStore{Path: filepath.Join(t.TempDir(), "env.json")}
+	require.NoError(t, store.Save(stateConfig()))
+	loaded, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, environment.SecretStateStore, loaded.StateStore)
+	require.Equal(t, 53412, loaded.Charts["geth"].ChartConnections["geth_0_geth-network"].LocalPorts["ws-rpc"])
+}
+
+// memoryStore keeps the configs it's given
+type memoryStore struct {
+	saved []*environment.Config
+}
+
+func (s *memoryStore) Save(config *environment.Config) error {
+	s.saved = append(s.saved, config)
+	return nil
+}
+
+func (s *memoryStore) Load() (*environment.Config, error) {
+	return s.saved[len(s.saved)-1], nil
+}
+
+func TestEnvironmentStateStore(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	config := stateConfig()
+	config.Persistent = true
+	config.Path = filepath.Join(t.TempDir(), "env.yaml")
+	e := environment.NewEnvironmentWithClient(config, client)
+	store := &memoryStore{}
+	e.SetStateStore(store)
+	require.NoError(t, e.SyncConfig())
+	require.NoError(t, e.SyncConfigJson())
+	require.Equal(t, []*environment.Config{config, config}, store.saved)
+	require.NoFileExists(t, config.Path, "the store replaces the environment file")
+	_, err := client.CoreV1().Secrets("chainlink-abcde").Get(context.Background(), environment.StateObjectName, metaV1.GetOptions{})
+	require.Error(t, err, "the store replaces the one picked by state_store")
+
+	e.SetStateStore(nil)
+	require.NoError(t, e.SyncConfig())
+	require.FileExists(t, config.Path)
+	_, err = client.CoreV1().Secrets("chainlink-abcde").Get(context.Background(), environment.StateObjectName, metaV1.GetOptions{})
+	require.NoError(t, err)
+}
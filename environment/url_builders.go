@@ -0,0 +1,218 @@
+package environment
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+)
+
+const (
+	// PostgresURL plain postgres:// URLs
+	PostgresURL = "postgres"
+	// GRPCURL plain grpc:// URLs
+	GRPCURL = "grpc"
+	// TCPURL plain tcp:// URLs
+	TCPURL = "tcp"
+	// ChainlinkDBURL postgres URLs of the database of the embedded chainlink chart
+	ChainlinkDBURL = "chainlink-db"
+	// LocalterraDBURL postgres URLs of the fcd database of the embedded localterra chart
+	LocalterraDBURL = "localterra-db"
+)
+
+// URLBuilder builds URLs of a scheme with an optional path, query and credentials
+type URLBuilder struct {
+	Scheme      string
+	Path        string
+	Query       url.Values
+	Credentials *URLCredentials
+}
+
+// URLCredentials user info of built URLs, either set directly, read from the keys of a Secret in the environment
+// namespace or read from credentials declared by the chart of the connection the URL is built for
+type URLCredentials struct {
+	Username           string
+	Password           string
+	SecretName         string
+	UsernameKey        string
+	PasswordKey        string
+	UsernameCredential string
+	PasswordCredential string
+
+	resolved bool
+}
+
+var (
+	urlBuildersMu sync.RWMutex
+	urlBuilders   = map[string]*URLBuilder{
+		"ws":        {Scheme: "ws"},
+		"wss":       {Scheme: "wss"},
+		"http":      {Scheme: "http"},
+		"https":     {Scheme: "https"},
+		PostgresURL: {Scheme: "postgres"},
+		GRPCURL:     {Scheme: "grpc"},
+		TCPURL:      {Scheme: "tcp"},
+		ChainlinkDBURL: {
+			Scheme:      "postgres",
+			Path:        "chainlink",
+			Query:       url.Values{"sslmode": []string{"disable"}},
+			Credentials: &URLCredentials{UsernameCredential: "db-username", PasswordCredential: "db-password"},
+		},
+		LocalterraDBURL: {
+			Scheme:      "postgres",
+			Path:        "fcd",
+			Query:       url.Values{"sslmode": []string{"disable"}},
+			Credentials: &URLCredentials{UsernameCredential: "db-username", PasswordCredential: "db-password"},
+		},
+	}
+)
+
+// RegisterURLBuilder makes a URL builder available by name
+func RegisterURLBuilder(name string, builder *URLBuilder) {
+	urlBuildersMu.Lock()
+	defer urlBuildersMu.Unlock()
+	urlBuilders[name] = builder
+}
+
+// GetURLBuilder returns a copy of a registered URL builder, credentials kept in a Secret are resolved
+// by Environment.URLBuilder
+func GetURLBuilder(name string) (*URLBuilder, error) {
+	urlBuildersMu.RLock()
+	defer urlBuildersMu.RUnlock()
+	builder, ok := urlBuilders[name]
+	if !ok {
+		return nil, fmt.Errorf("URL builder %s doesn't exist", name)
+	}
+	return builder.copy(), nil
+}
+
+// URLBuilder returns a copy of a registered URL builder with credentials read from Secrets of the environment
+func (k *Environment) URLBuilder(name string) (*URLBuilder, error) {
+	builder, err := GetURLBuilder(name)
+	if err != nil {
+		return nil, err
+	}
+	c := builder.Credentials
+	if c == nil || c.SecretName == "" || c.resolved || c.fromChart() {
+		return builder, nil
+	}
+	if c.UsernameKey != "" {
+		if c.Username, err = k.GetSecretField(k.Namespace, c.SecretName, c.UsernameKey); err != nil {
+			return nil, err
+		}
+	}
+	if c.PasswordKey != "" {
+		if c.Password, err = k.GetSecretField(k.Namespace, c.SecretName, c.PasswordKey); err != nil {
+			return nil, err
+		}
+	}
+	c.resolved = true
+	return builder, nil
+}
+
+// withChartCredentials returns a copy of the builder with the credentials it names read from the chart
+func (b *URLBuilder) withChartCredentials(hc *HelmChart) (*URLBuilder, error) {
+	if b.Credentials == nil || !b.Credentials.fromChart() || b.Credentials.resolved {
+		return b, nil
+	}
+	c := b.copy()
+	if name := c.Credentials.UsernameCredential; name != "" {
+		username, err := hc.ResolveCredential(name)
+		if err != nil {
+			return nil, err
+		}
+		c.Credentials.Username = username.Value()
+	}
+	if name := c.Credentials.PasswordCredential; name != "" {
+		password, err := hc.ResolveCredential(name)
+		if err != nil {
+			return nil, err
+		}
+		c.Credentials.Password = password.Value()
+	}
+	c.Credentials.resolved = true
+	return c, nil
+}
+
+// fromChart returns true if the credentials are read from credentials declared by a chart
+func (c *URLCredentials) fromChart() bool {
+	return c.UsernameCredential != "" || c.PasswordCredential != ""
+}
+
+// WithPath returns a copy of the builder using another path
+func (b *URLBuilder) WithPath(p string) *URLBuilder {
+	c := b.copy()
+	c.Path = p
+	return c
+}
+
+// WithQuery returns a copy of the builder with a query parameter set
+func (b *URLBuilder) WithQuery(key, value string) *URLBuilder {
+	c := b.copy()
+	c.Query.Set(key, value)
+	return c
+}
+
+// Build returns the URL of a host and port
+func (b *URLBuilder) Build(host string, port int) (*url.URL, error) {
+	return b.build(net.JoinHostPort(host, strconv.Itoa(port)), "")
+}
+
+// build returns the URL of an address, the path of the builder is appended to the path prefix
+func (b *URLBuilder) build(hostPort string, pathPrefix string) (*url.URL, error) {
+	u := &url.URL{Scheme: b.Scheme, Host: hostPort}
+	if pathPrefix != "" || b.Path != "" {
+		u.Path = path.Join("/", pathPrefix, b.Path)
+	}
+	if len(b.Query) > 0 {
+		u.RawQuery = b.Query.Encode()
+	}
+	if c := b.Credentials; c != nil {
+		if c.fromChart() && !c.resolved {
+			return nil, fmt.Errorf("credentials of the chart aren't resolved, build URLs of chart connections with a connection query")
+		}
+		if c.SecretName != "" && !c.resolved {
+			return nil, fmt.Errorf("credentials of Secret %s aren't resolved, get the builder from Environment.URLBuilder", c.SecretName)
+		}
+		switch {
+		case c.Password != "":
+			u.User = url.UserPassword(c.Username, c.Password)
+		case c.Username != "":
+			u.User = url.User(c.Username)
+		}
+	}
+	return u, nil
+}
+
+// buildLocal returns the URL a port is reachable on from outside the cluster
+func (b *URLBuilder) buildLocal(hosts map[string]string, paths map[string]string, portName string, localPort int) (*url.URL, error) {
+	host := "localhost"
+	if h, ok := hosts[portName]; ok {
+		host = h
+	}
+	return b.build(net.JoinHostPort(host, strconv.Itoa(localPort)), paths[portName])
+}
+
+func (b *URLBuilder) copy() *URLBuilder {
+	c := *b
+	c.Query = url.Values{}
+	for key, values := range b.Query {
+		c.Query[key] = append([]string{}, values...)
+	}
+	if b.Credentials != nil {
+		credentials := *b.Credentials
+		c.Credentials = &credentials
+	}
+	return &c
+}
+
+// builder returns the URL builder of the protocol scheme
+func (p Protocol) builder() (*URLBuilder, error) {
+	scheme, err := p.scheme()
+	if err != nil {
+		return nil, err
+	}
+	return &URLBuilder{Scheme: scheme}, nil
+}
@@ -0,0 +1,230 @@
+package environment
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// secretReferenceRegexp matches values resolved at deploy time, e.g. ${env:DATABASE_URL}, ${file:/run/secrets/key}
+// or ${k8s-secret:db-credentials/password}
+var secretReferenceRegexp = regexp.MustCompile(`\$\{(env|file|k8s-secret):([^}]*)\}`)
+
+// ResolveSecretReferences returns a copy of the values with references to environment variables, files and Secrets
+// of the namespace replaced by what they refer to, the paths of the resolved values are redacted in logs
+func (hc *HelmChart) ResolveSecretReferences(values map[string]interface{}) (map[string]interface{}, error) {
+	if len(values) == 0 {
+		return values, nil
+	}
+	var paths []string
+	resolved, err := walkValues(values, "", func(path string, v interface{}) (interface{}, error) {
+		value, ok := v.(string)
+		if !ok {
+			return v, nil
+		}
+		resolvedValue, err := hc.resolveReferences(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve %s of chart %s", path, hc.ReleaseName)
+		}
+		if resolvedValue != value {
+			paths = append(paths, path)
+		}
+		return resolvedValue, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	hc.secretPaths = paths
+	return resolved.(map[string]interface{}), nil
+}
+
+// resolveReferences returns the string with every reference replaced by what it refers to
+func (hc *HelmChart) resolveReferences(value string) (string, error) {
+	var resolveErr error
+	resolved := secretReferenceRegexp.ReplaceAllStringFunc(value, func(reference string) string {
+		match := secretReferenceRegexp.FindStringSubmatch(reference)
+		s, err := hc.resolveSecretReference(match[1], match[2])
+		if err != nil && resolveErr == nil {
+			resolveErr = err
+		}
+		return s
+	})
+	return resolved, resolveErr
+}
+
+func (hc *HelmChart) resolveSecretReference(kind string, reference string) (string, error) {
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(reference)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s isn't set", reference)
+		}
+		return value, nil
+	case "file":
+		b, err := os.ReadFile(reference)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(string(b), "\n"), nil
+	default:
+		name, key, ok := strings.Cut(reference, "/")
+		if !ok || name == "" || key == "" {
+			return "", fmt.Errorf("secret reference %s must be given as name/key", reference)
+		}
+		if hc.env == nil {
+			return "", fmt.Errorf("secret %s can't be read outside of an environment", name)
+		}
+		return hc.env.GetSecretField(hc.namespaceName, name, key)
+	}
+}
+
+// checkSecretReferences returns the problems of the references in the values of a chart
+func checkSecretReferences(values map[string]interface{}) []string {
+	var problems []string
+	_, _ = walkValues(values, "", func(path string, value interface{}) (interface{}, error) {
+		s, _ := value.(string)
+		for _, match := range secretReferenceRegexp.FindAllStringSubmatch(s, -1) {
+			if match[2] == "" {
+				problems = append(problems, fmt.Sprintf("value %s refers to an empty %s", path, match[1]))
+				continue
+			}
+			if name, key, ok := strings.Cut(match[2], "/"); match[1] == "k8s-secret" && (!ok || name == "" || key == "") {
+				problems = append(problems, fmt.Sprintf("value %s must refer to a secret as name/key, got %s", path, match[2]))
+			}
+		}
+		return value, nil
+	})
+	return problems
+}
+
+// RedactValues returns a copy of the values with the redacted paths of the chart and the environment, and the values
+// resolved from references, replaced so they can be logged
+func (hc *HelmChart) RedactValues(values map[string]interface{}) map[string]interface{} {
+	return redactValues(values, append(append(hc.redactedPaths(), hc.secretPaths...), hc.templatePaths...))
+}
+
+// redactedPaths returns the value paths redacted by the chart and the environment
+func (hc *HelmChart) redactedPaths() []string {
+	paths := append([]string{}, hc.RedactedValues...)
+	if hc.env != nil {
+		paths = append(paths, hc.env.Config.RedactedValues...)
+	}
+	return paths
+}
+
+// redactValues returns a copy of the values with the values at the paths, or below them, redacted
+func redactValues(values map[string]interface{}, paths []string) map[string]interface{} {
+	if len(paths) == 0 || len(values) == 0 {
+		return values
+	}
+	redactedValues, _ := walkValues(values, "", func(path string, value interface{}) (interface{}, error) {
+		if isRedactedPath(path, paths) {
+			return redacted, nil
+		}
+		return value, nil
+	})
+	return redactedValues.(map[string]interface{})
+}
+
+// isRedactedPath returns true if the path is one of the paths or below one of them
+func isRedactedPath(path string, paths []string) bool {
+	for _, p := range paths {
+		if path == p || strings.HasPrefix(path, p+".") || strings.HasPrefix(path, p+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSet redacts the values of --set overrides of redacted paths so they can be logged
+func redactSet(sets []string, paths []string) []string {
+	if len(paths) == 0 {
+		return sets
+	}
+	redactedSets := make([]string, 0, len(sets))
+	for _, set := range sets {
+		if key, _, _ := strings.Cut(set, "="); isRedactedPath(key, paths) {
+			set = key + "=" + redacted
+		}
+		redactedSets = append(redactedSets, set)
+	}
+	return redactedSets
+}
+
+// literalRedactedValues returns the problems of the values and --set overrides of a chart at the redacted paths that
+// aren't references, they would be written to the environment file as they are
+func (hc *HelmChart) literalRedactedValues(paths []string) []string {
+	paths = append(append([]string{}, paths...), hc.RedactedValues...)
+	if len(paths) == 0 {
+		return nil
+	}
+	var problems []string
+	_, _ = walkValues(hc.Values, "", func(path string, value interface{}) (interface{}, error) {
+		if s, _ := value.(string); isRedactedPath(path, paths) && !isReference(s) {
+			problems = append(problems, fmt.Sprintf("value %s is redacted but isn't a reference such as ${env:NAME}", path))
+		}
+		return value, nil
+	})
+	for _, set := range hc.Set {
+		if key, value, _ := strings.Cut(set, "="); isRedactedPath(key, paths) && !isReference(value) {
+			problems = append(problems, fmt.Sprintf("--set %s is redacted but isn't a reference such as ${env:NAME}", key))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// checkRedactedValues returns an error if a chart of the config has a value at a redacted path that isn't a
+// reference, it can't be written without writing the secret or losing it
+func checkRedactedValues(cfg *Config) error {
+	for _, name := range sortedKeys(cfg.Charts) {
+		if hc := cfg.Charts[name]; hc != nil {
+			if problems := hc.literalRedactedValues(cfg.RedactedValues); len(problems) > 0 {
+				return fmt.Errorf("chart %s can't be written: %s", name, strings.Join(problems, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// isReference returns true if the value is resolved when the chart is deployed, from a secret or another chart
+func isReference(value string) bool {
+	return secretReferenceRegexp.MatchString(value) || strings.Contains(value, referenceDelimiter)
+}
+
+// walkValues returns a copy of the values with every value that isn't a map or a list replaced by the result of the
+// function, lists are indexed in paths like in --set overrides, e.g. env[0].value
+func walkValues(value interface{}, path string, fn func(path string, value interface{}) (interface{}, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		walked := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			itemPath := key
+			if path != "" {
+				itemPath = path + "." + key
+			}
+			w, err := walkValues(item, itemPath, fn)
+			if err != nil {
+				return nil, err
+			}
+			walked[key] = w
+		}
+		return walked, nil
+	case []interface{}:
+		walked := make([]interface{}, len(v))
+		for i, item := range v {
+			w, err := walkValues(item, fmt.Sprintf("%s[%d]", path, i), fn)
+			if err != nil {
+				return nil, err
+			}
+			walked[i] = w
+		}
+		return walked, nil
+	default:
+		return fn(path, v)
+	}
+}
@@ -0,0 +1,75 @@
+package environment_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecretReferences(t *testing.T) {
+	t.Setenv("HELMENV_TEST_DB_PASSWORD", "hunter2")
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("0xabc\n"), 0600))
+	hc := &environment.HelmChart{
+		ReleaseName: "chainlink",
+		Values: map[string]interface{}{
+			"db":       map[string]interface{}{"url": "postgres://chainlink:${env:HELMENV_TEST_DB_PASSWORD}@db:5432"},
+			"keys":     []interface{}{"${file:" + keyFile + "}"},
+			"replicas": 1,
+		},
+	}
+	values, err := hc.ResolveSecretReferences(hc.Values)
+	require.NoError(t, err)
+	require.Equal(t, "postgres://chainlink:hunter2@db:5432", values["db"].(map[string]interface{})["url"])
+	require.Equal(t, []interface{}{"0xabc"}, values["keys"])
+	require.Equal(t, "postgres://chainlink:${env:HELMENV_TEST_DB_PASSWORD}@db:5432", hc.Values["db"].(map[string]interface{})["url"])
+	require.Equal(t, map[string]interface{}{
+		"db":       map[string]interface{}{"url": "[REDACTED]"},
+		"keys":     []interface{}{"[REDACTED]"},
+		"replicas": 1,
+	}, hc.RedactValues(values))
+
+	_, err = hc.ResolveSecretReferences(map[string]interface{}{"password": "${env:HELMENV_TEST_UNSET}"})
+	require.EqualError(t, err, "failed to resolve password of chart chainlink: environment variable HELMENV_TEST_UNSET isn't set")
+	_, err = hc.ResolveSecretReferences(map[string]interface{}{"password": "${k8s-secret:db/password}"})
+	require.ErrorContains(t, err, "secret db can't be read outside of an environment")
+}
+
+func TestDumpConfigRedactsValues(t *testing.T) {
+	t.Parallel()
+	config := &environment.Config{
+		NamespacePrefix: "chainlink",
+		RedactedValues:  []string{"db"},
+		Charts: environment.Charts{
+			"chainlink": {
+				Index:          1,
+				RedactedValues: []string{"env.API_PASSWORD"},
+				Values: map[string]interface{}{
+					"db":  map[string]interface{}{"password": "hunter2", "user": "${env:DB_USER}"},
+					"env": map[string]interface{}{"API_PASSWORD": "${file:/run/secrets/api}", "LOG_LEVEL": "debug"},
+				},
+				Set: []string{"db.password=hunter3", "env.API_PASSWORD=${k8s-secret:api/password}"},
+			},
+		},
+	}
+	require.ErrorContains(t, config.Validate(), "chart chainlink: value db.password is redacted but isn't a reference such as ${env:NAME}")
+	require.ErrorContains(t, config.Validate(), "chart chainlink: --set db.password is redacted but isn't a reference such as ${env:NAME}")
+	path := filepath.Join(t.TempDir(), "env.yaml")
+	require.EqualError(t, environment.DumpConfig(config, path), "chart chainlink can't be written: --set db.password is redacted but isn't a reference such as ${env:NAME}, value db.password is redacted but isn't a reference such as ${env:NAME}")
+	require.NoFileExists(t, path, "the secret isn't written, nor a placeholder instead of it")
+
+	config.Charts["chainlink"].Values["db"] = map[string]interface{}{"password": "${env:DB_PASSWORD}", "user": "${env:DB_USER}"}
+	config.Charts["chainlink"].Set = []string{"db.password=${env:DB_PASSWORD_OVERRIDE}", "env.API_PASSWORD=${k8s-secret:api/password}"}
+	require.NoError(t, config.Validate())
+	require.NoError(t, environment.DumpConfig(config, path))
+	dumped, err := environment.ReadConfigFile(path)
+	require.NoError(t, err)
+	require.Equal(t, config.Charts["chainlink"].Values, dumped.Charts["chainlink"].Values, "references survive a round trip")
+	require.Equal(t, config.Charts["chainlink"].Set, dumped.Charts["chainlink"].Set)
+
+	config.Charts["chainlink"].Values["db"] = map[string]interface{}{"password": "${k8s-secret:db-password}"}
+	require.ErrorContains(t, config.Validate(), "chart chainlink: value db.password must refer to a secret as name/key, got db-password")
+}
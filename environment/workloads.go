@@ -0,0 +1,175 @@
+package environment
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"helm.sh/helm/v3/pkg/action"
+	batchV1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Workload the resource of a release that owns a pod, e.g. a StatefulSet, Deployment, DaemonSet or Job
+type Workload struct {
+	Kind string `yaml:"kind" json:"kind" envconfig:"kind"`
+	Name string `yaml:"name" json:"name" envconfig:"name"`
+}
+
+// String returns the workload as kind/name
+func (w Workload) String() string {
+	return w.Kind + "/" + w.Name
+}
+
+// podContainer a container of any kind with the ports it exposes
+type podContainer struct {
+	name  string
+	ports []v1.ContainerPort
+}
+
+// releaseResources returns the resources of the release manifest
+func (hc *HelmChart) releaseResources() (map[Workload]bool, error) {
+	release, err := action.NewGet(hc.actionConfig).Run(hc.ReleaseName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get release %s", hc.ReleaseName)
+	}
+	infos, err := hc.actionConfig.KubeClient.Build(strings.NewReader(release.Manifest), false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the manifest of release %s", hc.ReleaseName)
+	}
+	resources := map[Workload]bool{}
+	for _, info := range infos {
+		kind := info.Object.GetObjectKind().GroupVersionKind().Kind
+		if info.Mapping != nil {
+			kind = info.Mapping.GroupVersionKind.Kind
+		}
+		resources[Workload{Kind: kind, Name: info.Name}] = true
+	}
+	return resources, nil
+}
+
+// fetchPods lists the running pods owned by resources of the release manifest
+func (hc *HelmChart) fetchPods() error {
+	resources, err := hc.releaseResources()
+	if err != nil {
+		return err
+	}
+	return hc.fetchWorkloadPods(resources)
+}
+
+// fetchWorkloadPods lists the running pods owned by the resources, pods of finished Jobs are skipped
+func (hc *HelmChart) fetchWorkloadPods(resources map[Workload]bool) error {
+	l, err := hc.env.k8sClient.CoreV1().Pods(hc.namespaceName).List(context.Background(), metaV1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	owners := &workloadResolver{hc: hc, workloads: map[Workload]Workload{}, finished: map[string]bool{}}
+	hc.podsList = &v1.PodList{}
+	hc.podWorkloads = map[string]Workload{}
+	for _, pod := range l.Items {
+		if !isActivePod(&pod) {
+			continue
+		}
+		workload, finished, err := owners.resolve(&pod)
+		if err != nil {
+			return err
+		}
+		if !resources[workload] {
+			continue
+		}
+		if finished {
+			log.Debug().Str("Pod", pod.Name).Str("Workload", workload.String()).Msg("Skipping pod of a finished Job")
+			continue
+		}
+		hc.podsList.Items = append(hc.podsList.Items, pod)
+		hc.podWorkloads[pod.Name] = workload
+	}
+	return nil
+}
+
+// podContainers returns the containers of a pod, with init and ephemeral containers if the chart includes them
+func (hc *HelmChart) podContainers(pod *v1.Pod) []podContainer {
+	var containers []podContainer
+	if hc.InitContainers {
+		for _, c := range pod.Spec.InitContainers {
+			containers = append(containers, podContainer{name: c.Name, ports: c.Ports})
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, podContainer{name: c.Name, ports: c.Ports})
+	}
+	if hc.EphemeralContainers {
+		for _, c := range pod.Spec.EphemeralContainers {
+			containers = append(containers, podContainer{name: c.Name, ports: c.Ports})
+		}
+	}
+	return containers
+}
+
+// workloadResolver follows the controller owners of pods up to the workload declared in a manifest, looked up
+// owners are cached
+type workloadResolver struct {
+	hc        *HelmChart
+	workloads map[Workload]Workload
+	finished  map[string]bool
+}
+
+// resolve returns the workload of a pod, a pod without a controller is its own workload. Pods of ReplicaSets belong to
+// their Deployment and pods of Jobs to their CronJob, finished is true for pods of completed or failed Jobs
+func (r *workloadResolver) resolve(pod *v1.Pod) (Workload, bool, error) {
+	owner := metaV1.GetControllerOf(pod)
+	if owner == nil {
+		return Workload{Kind: "Pod", Name: pod.Name}, false, nil
+	}
+	workload := Workload{Kind: owner.Kind, Name: owner.Name}
+	switch owner.Kind {
+	case "ReplicaSet":
+		resolved, err := r.parent(workload)
+		return resolved, false, err
+	case "Job":
+		resolved, err := r.parent(workload)
+		return resolved, r.finished[owner.Name], err
+	}
+	return workload, false, nil
+}
+
+// parent returns the controller of a ReplicaSet or Job, or the workload itself if nothing controls it
+func (r *workloadResolver) parent(workload Workload) (Workload, error) {
+	if resolved, ok := r.workloads[workload]; ok {
+		return resolved, nil
+	}
+	var object metaV1.Object
+	switch workload.Kind {
+	case "ReplicaSet":
+		rs, err := r.hc.env.k8sClient.AppsV1().ReplicaSets(r.hc.namespaceName).Get(context.Background(), workload.Name, metaV1.GetOptions{})
+		if err != nil {
+			return workload, errors.Wrapf(err, "failed to get the owner %s of pods", workload)
+		}
+		object = rs
+	case "Job":
+		job, err := r.hc.env.k8sClient.BatchV1().Jobs(r.hc.namespaceName).Get(context.Background(), workload.Name, metaV1.GetOptions{})
+		if err != nil {
+			return workload, errors.Wrapf(err, "failed to get the owner %s of pods", workload)
+		}
+		r.finished[job.Name] = isFinishedJob(job)
+		object = job
+	}
+	resolved := workload
+	if owner := metaV1.GetControllerOf(object); owner != nil {
+		resolved = Workload{Kind: owner.Kind, Name: owner.Name}
+	}
+	r.workloads[workload] = resolved
+	return resolved, nil
+}
+
+// isFinishedJob returns true once a Job completed or failed
+func isFinishedJob(job *batchV1.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchV1.JobComplete || c.Type == batchV1.JobFailed) && c.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package environment_test
+
+import (
+	"testing"
+
+	"github.com/smartcontractkit/helmenv/environment"
+	"github.com/stretchr/testify/require"
+	appsV1 "k8s.io/api/apps/v1"
+	batchV1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func ownedMeta(name, ownerKind, owner string) metaV1.ObjectMeta {
+	controller := true
+	return metaV1.ObjectMeta{
+		Name:            name,
+		Namespace:       "env",
+		OwnerReferences: []metaV1.OwnerReference{{Kind: ownerKind, Name: owner, Controller: &controller}},
+	}
+}
+
+func workloadPod(name, ownerKind, owner string) *v1.Pod {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "migrate"}},
+			Containers:     []v1.Container{{Name: "node", Ports: []v1.ContainerPort{{Name: "access", ContainerPort: 6688}}}},
+			EphemeralContainers: []v1.EphemeralContainer{{EphemeralContainerCommon: v1.EphemeralContainerCommon{
+				Name:  "debugger",
+				Ports: []v1.ContainerPort{{Name: "debug", ContainerPort: 2345}},
+			}}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+	if ownerKind == "" {
+		pod.ObjectMeta = metaV1.ObjectMeta{Name: name, Namespace: "env"}
+	} else {
+		pod.ObjectMeta = ownedMeta(name, ownerKind, owner)
+	}
+	return pod
+}
+
+func finishedJob(name string, conditionType batchV1.JobConditionType) *batchV1.Job {
+	return &batchV1.Job{
+		ObjectMeta: ownedMeta(name, "CronJob", "backup"),
+		Status: batchV1.JobStatus{Conditions: []batchV1.JobCondition{
+			{Type: conditionType, Status: v1.ConditionTrue},
+		}},
+	}
+}
+
+func workloadObjects() []runtime.Object {
+	return []runtime.Object{
+		&appsV1.ReplicaSet{ObjectMeta: ownedMeta("chainlink-5d8f", "Deployment", "chainlink")},
+		&appsV1.ReplicaSet{ObjectMeta: ownedMeta("other-7c9b", "Deployment", "other")},
+		&batchV1.Job{ObjectMeta: ownedMeta("backup-3", "CronJob", "backup")},
+		finishedJob("backup-2", batchV1.JobComplete),
+		finishedJob("backup-1", batchV1.JobFailed),
+		&batchV1.Job{ObjectMeta: metaV1.ObjectMeta{Name: "migrations", Namespace: "env"}},
+		workloadPod("chainlink-5d8f-x2k4p", "ReplicaSet", "chainlink-5d8f"),
+		workloadPod("other-7c9b-q8w2z", "ReplicaSet", "other-7c9b"),
+		workloadPod("backup-3-m4n7v", "Job", "backup-3"),
+		workloadPod("backup-2-k9j3h", "Job", "backup-2"),
+		workloadPod("backup-1-c5t8r", "Job", "backup-1"),
+		workloadPod("migrations-b2d6f", "Job", "migrations"),
+		workloadPod("standalone", "", ""),
+	}
+}
+
+func workloadConnections(t *testing.T, chart *environment.HelmChart) map[string]environment.Workload {
+	connections := map[string]environment.Workload{}
+	chart.ChartConnections.Range(func(key string, connection *environment.ChartConnection) bool {
+		require.NotNil(t, connection.Workload, key)
+		connections[key] = *connection.Workload
+		return true
+	})
+	return connections
+}
+
+func TestWorkloadConnections(t *testing.T) {
+	t.Parallel()
+	e := environment.NewEnvironmentWithClient(&environment.Config{Namespace: "env"}, fake.NewSimpleClientset(workloadObjects()...))
+	chart := &environment.HelmChart{ReleaseName: "chainlink", Index: 1}
+	require.NoError(t, e.AddChart(chart))
+	require.NoError(t, chart.UpdateWorkloadConnections(
+		environment.Workload{Kind: "Deployment", Name: "chainlink"},
+		environment.Workload{Kind: "CronJob", Name: "backup"},
+		environment.Workload{Kind: "Job", Name: "migrations"},
+		environment.Workload{Kind: "Pod", Name: "standalone"},
+	))
+	deployment := environment.Workload{Kind: "Deployment", Name: "chainlink"}
+	cronJob := environment.Workload{Kind: "CronJob", Name: "backup"}
+	require.Equal(t, map[string]environment.Workload{
+		"chainlink_0_node":  deployment,
+		"backup_0_node":     cronJob,
+		"migrations_0_node": {Kind: "Job", Name: "migrations"},
+		"standalone_0_node": {Kind: "Pod", Name: "standalone"},
+	}, workloadConnections(t, chart), "pods of other releases and of finished Jobs are skipped")
+	backup, err := chart.ChartConnections.Load("backup", "0", "node")
+	require.NoError(t, err)
+	require.Equal(t, "backup-3-m4n7v", backup.PodName, "the pod of the running Job is kept")
+}
+
+func TestWorkloadConnectionsContainers(t *testing.T) {
+	t.Parallel()
+	e := environment.NewEnvironmentWithClient(&environment.Config{Namespace: "env"}, fake.NewSimpleClientset(workloadObjects()...))
+	chart := &environment.HelmChart{ReleaseName: "chainlink", Index: 1, InitContainers: true, EphemeralContainers: true}
+	require.NoError(t, e.AddChart(chart))
+	require.NoError(t, chart.UpdateWorkloadConnections(environment.Workload{Kind: "Deployment", Name: "chainlink"}))
+	deployment := environment.Workload{Kind: "Deployment", Name: "chainlink"}
+	require.Equal(t, map[string]environment.Workload{
+		"chainlink_0_migrate":  deployment,
+		"chainlink_0_node":     deployment,
+		"chainlink_0_debugger": deployment,
+	}, workloadConnections(t, chart))
+	debugger, err := chart.ChartConnections.Load("chainlink", "0", "debugger")
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"debug": 2345}, debugger.RemotePorts)
+}
+
+func TestWorkloadConnectionsMissingOwner(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset(workloadPod("chainlink-5d8f-x2k4p", "ReplicaSet", "chainlink-5d8f"))
+	e := environment.NewEnvironmentWithClient(&environment.Config{Namespace: "env"}, client)
+	chart := &environment.HelmChart{ReleaseName: "chainlink", Index: 1}
+	require.NoError(t, e.AddChart(chart))
+	require.ErrorContains(t, chart.UpdateWorkloadConnections(), "failed to get the owner ReplicaSet/chainlink-5d8f of pods")
+}